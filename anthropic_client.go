@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultAnthropicBaseURL = "https://api.anthropic.com/v1"
+	anthropicAPIVersion     = "2023-06-01"
+)
+
+// anthropicProvider talks to the Anthropic Messages API. The custom
+// instructions become the system prompt and, with prompt caching, the file
+// block is marked with cache_control so follow-up questions reuse it.
+type anthropicProvider struct{}
+
+func (anthropicProvider) defaultBaseURL() string { return defaultAnthropicBaseURL }
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// anthropicStreamEvent holds the fields of every streamed event type
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message *struct {
+		ID    string         `json:"id"`
+		Model string         `json:"model"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Delta *struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (anthropicProvider) streamChat(ctx context.Context, request ChatRequest, split *splitPrompt, onDelta func(text string)) (*ChatResponse, error) {
+	body := newAnthropicMessagesRequest(request.exportRequest(), split)
+	body.Stream = true
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request body: %v", err)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, providerURL(request, defaultAnthropicBaseURL, "/messages"), bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	key := providerAPIKey(request, "ANTHROPIC_API_KEY")
+	if key == "" && !usesDefaultEndpoint(request) {
+		return nil, fmt.Errorf("an API key is required for %s", request.BaseURL)
+	}
+	if key == "" {
+		return nil, fmt.Errorf("an Anthropic API key is required")
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Accept", "text/event-stream")
+	httpRequest.Header.Set("x-api-key", key)
+	httpRequest.Header.Set("anthropic-version", anthropicAPIVersion)
+
+	httpResponse, err := sendProviderRequest(httpRequest, request.Provider, split.tokens()+request.MaxTokens)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	response := &ChatResponse{Model: request.Model}
+	var content strings.Builder
+	err = readSSE(httpResponse.Body, func(eventType string, data string) error {
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("error parsing response stream: %v", err)
+		}
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				response.Model = event.Message.Model
+				addAnthropicUsage(&response.Usage, event.Message.Usage)
+			}
+		case "content_block_delta":
+			if event.Delta != nil && event.Delta.Type == "text_delta" {
+				content.WriteString(event.Delta.Text)
+				onDelta(event.Delta.Text)
+			}
+		case "message_delta":
+			if event.Delta != nil && event.Delta.StopReason != "" {
+				response.FinishReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				addAnthropicUsage(&response.Usage, *event.Usage)
+			}
+		case "message_stop":
+			return io.EOF
+		case "error":
+			if event.Error != nil {
+				return fmt.Errorf("%s error: %s: %s", request.Provider, event.Error.Type, event.Error.Message)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	response.Content = content.String()
+	return response, nil
+}
+
+// addAnthropicUsage merges streamed usage. message_start carries the input
+// counts and message_delta the final output count, so the largest seen wins.
+func addAnthropicUsage(usage *ChatUsage, reported anthropicUsage) {
+	usage.InputTokens = max(usage.InputTokens, reported.InputTokens)
+	usage.OutputTokens = max(usage.OutputTokens, reported.OutputTokens)
+	usage.CacheWriteTokens = max(usage.CacheWriteTokens, reported.CacheCreationInputTokens)
+	usage.CacheReadTokens = max(usage.CacheReadTokens, reported.CacheReadInputTokens)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnthropicStreamChat(t *testing.T) {
+	recorded, err := os.ReadFile(filepath.Join("testdata", "anthropic_stream.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(source, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var body anthropicMessagesRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") != anthropicAPIVersion {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("error decoding request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(recorded)
+	}))
+	defer server.Close()
+
+	request := testChatRequest("anthropic", server.URL+"/v1")
+	request.PromptCaching = true
+	request.Prompt.Files = []SelectedFile{{Path: source}}
+	var deltas []string
+	response, err := streamTestChat(t, anthropicProvider{}, context.Background(), request, func(text string) {
+		deltas = append(deltas, text)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deltas, "|") != "Hello| there" || response.Content != "Hello there" {
+		t.Errorf("deltas = %q, content = %q", deltas, response.Content)
+	}
+	if response.Model != "claude-sonnet-4-20250514" || response.FinishReason != "end_turn" {
+		t.Errorf("model = %q, finish reason = %q", response.Model, response.FinishReason)
+	}
+	// message_start carries the input and cache counts, message_delta the output
+	want := ChatUsage{InputTokens: 12, OutputTokens: 15, CacheWriteTokens: 2048, CacheReadTokens: 1024}
+	if response.Usage != want {
+		t.Errorf("usage = %+v, want %+v", response.Usage, want)
+	}
+
+	if !body.Stream || len(body.System) != 1 || body.System[0].Text != "Be brief." {
+		t.Errorf("unexpected request body: %+v", body)
+	}
+	if len(body.Messages) != 1 || len(body.Messages[0].Content) != 2 {
+		t.Fatalf("unexpected messages: %+v", body.Messages)
+	}
+	if files := body.Messages[0].Content[0]; files.CacheControl == nil || !strings.Contains(files.Text, "package main") {
+		t.Errorf("the file block is not cacheable: %+v", files)
+	}
+	if task := body.Messages[0].Content[1]; task.CacheControl != nil || !strings.Contains(task.Text, "Say hello.") {
+		t.Errorf("unexpected task block: %+v", task)
+	}
+}
+
+func TestAnthropicStreamChatErrors(t *testing.T) {
+	t.Run("status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+		}))
+		defer server.Close()
+		_, err := streamTestChat(t, anthropicProvider{}, context.Background(), testChatRequest("anthropic", server.URL), func(string) {})
+		var providerErr *ProviderError
+		if !errors.As(err, &providerErr) || providerErr.Kind != ProviderErrorAuth {
+			t.Errorf("error = %v, want an auth error", err)
+		}
+	})
+	t.Run("stream", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeSSE(w, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}")
+		}))
+		defer server.Close()
+		_, err := streamTestChat(t, anthropicProvider{}, context.Background(), testChatRequest("anthropic", server.URL), func(string) {})
+		if err == nil || !strings.Contains(err.Error(), "overloaded_error: Overloaded") {
+			t.Errorf("error = %v", err)
+		}
+	})
+	t.Run("cut off", func(t *testing.T) {
+		recorded, err := os.ReadFile(filepath.Join("testdata", "anthropic_stream.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cut, _, found := strings.Cut(string(recorded), "event: message_stop")
+		if !found {
+			t.Fatal("the recorded stream has no message_stop")
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(cut))
+		}))
+		defer server.Close()
+		_, err = streamTestChat(t, anthropicProvider{}, context.Background(), testChatRequest("anthropic", server.URL), func(string) {})
+		if !errors.Is(err, errIncompleteStream) {
+			t.Errorf("error = %v, want an incomplete stream", err)
+		}
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	defaultExportFormat       = "markdown"
+	defaultAnthropicMaxTokens = 4096
+)
+
+// ExportRequest selects the provider request body built from a prompt
+type ExportRequest struct {
+	Prompt      PromptRequest `json:"prompt"`
+	Provider    string        `json:"provider"`
+	Model       string        `json:"model"`
+	MaxTokens   int           `json:"maxTokens,omitempty"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	// PromptCaching marks the file block as cacheable where the provider supports it
+	PromptCaching bool `json:"promptCaching"`
+}
+
+type openAIMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+type openAIContentPart struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type openAIChatRequest struct {
+	Model         string               `json:"model"`
+	Messages      []openAIMessage      `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens,omitempty"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type anthropicCacheControl struct {
+	Type string `json:"type"`
+}
+
+type anthropicContentBlock struct {
+	Type         string                 `json:"type"`
+	Text         string                 `json:"text"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+type anthropicMessagesRequest struct {
+	Model       string                  `json:"model"`
+	MaxTokens   int                     `json:"max_tokens"`
+	System      []anthropicContentBlock `json:"system,omitempty"`
+	Messages    []anthropicMessage      `json:"messages"`
+	Temperature *float64                `json:"temperature,omitempty"`
+	Stream      bool                    `json:"stream,omitempty"`
+}
+
+// splitPrompt is a prompt divided into the parts provider APIs take separately:
+// the instructions become the system prompt, the files are the stable context
+// worth caching and the task is the question asked about them
+type splitPrompt struct {
+	System  string
+	Context string
+	Task    string
+}
+
+// buildSplitPrompt renders the file and task halves of a prompt separately
+func buildSplitPrompt(request PromptRequest) (*splitPrompt, error) {
+	if request.Format == "" {
+		request.Format = defaultExportFormat
+	}
+
+	contextRequest := request
+	contextRequest.Task, contextRequest.Instructions, contextRequest.RawPrompt = "", "", ""
+	context := ""
+	if len(request.Files) > 0 || request.Tree != nil || request.Diff != "" || request.Note != "" {
+		result, err := buildPrompt(contextRequest)
+		if err != nil {
+			return nil, err
+		}
+		context = result.Prompt
+	}
+
+	taskRequest := request
+	taskRequest.Instructions, taskRequest.Files, taskRequest.Tree, taskRequest.Diff, taskRequest.Note = "", nil, nil, "", ""
+	task, err := buildPrompt(taskRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &splitPrompt{System: request.Instructions, Context: context, Task: task.Prompt}, nil
+}
+
+// text joins the parts in the order a chat model reads them
+func (s *splitPrompt) text() string {
+	var parts []string
+	for _, part := range []string{s.System, s.Context, s.Task} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// tokens estimates the size of the prompt sent to a chat model
+func (s *splitPrompt) tokens() int {
+	return estimateTokens(s.System) + estimateTokens(s.Context) + estimateTokens(s.Task)
+}
+
+// buildAPIRequestBody builds the provider request body for an export request
+func buildAPIRequestBody(request ExportRequest) (interface{}, error) {
+	if request.Model == "" {
+		return nil, fmt.Errorf("a model is required")
+	}
+	split, err := buildSplitPrompt(request.Prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	switch request.Provider {
+	case "openai":
+		return newOpenAIChatRequest(request, split), nil
+	case "anthropic":
+		return newAnthropicMessagesRequest(request, split), nil
+	}
+	return nil, fmt.Errorf("unknown provider: %s", request.Provider)
+}
+
+// newOpenAIChatRequest builds a Chat Completions body. OpenAI caches long
+// prompt prefixes automatically, so the files simply come first.
+func newOpenAIChatRequest(request ExportRequest, split *splitPrompt) *openAIChatRequest {
+	body := &openAIChatRequest{
+		Model:       request.Model,
+		MaxTokens:   request.MaxTokens,
+		Temperature: request.Temperature,
+	}
+	if split.System != "" {
+		body.Messages = append(body.Messages, openAIMessage{Role: "system", Content: split.System})
+	}
+
+	var parts []openAIContentPart
+	if split.Context != "" {
+		parts = append(parts, openAIContentPart{Type: "text", Text: split.Context})
+	}
+	if split.Task != "" {
+		parts = append(parts, openAIContentPart{Type: "text", Text: split.Task})
+	}
+	body.Messages = append(body.Messages, openAIMessage{Role: "user", Content: parts})
+	return body
+}
+
+// newAnthropicMessagesRequest builds a Messages body, marking the file block
+// with cache_control when prompt caching is requested
+func newAnthropicMessagesRequest(request ExportRequest, split *splitPrompt) *anthropicMessagesRequest {
+	body := &anthropicMessagesRequest{
+		Model:       request.Model,
+		MaxTokens:   request.MaxTokens,
+		Temperature: request.Temperature,
+	}
+	if body.MaxTokens <= 0 {
+		body.MaxTokens = defaultAnthropicMaxTokens
+	}
+	if split.System != "" {
+		body.System = []anthropicContentBlock{{Type: "text", Text: split.System}}
+	}
+
+	var content []anthropicContentBlock
+	if split.Context != "" {
+		block := anthropicContentBlock{Type: "text", Text: split.Context}
+		if request.PromptCaching {
+			block.CacheControl = &anthropicCacheControl{Type: "ephemeral"}
+		}
+		content = append(content, block)
+	}
+	if split.Task != "" {
+		content = append(content, anthropicContentBlock{Type: "text", Text: split.Task})
+	}
+	body.Messages = []anthropicMessage{{Role: "user", Content: content}}
+	return body
+}
+
+// exportAPIRequest returns the indented JSON request body
+func exportAPIRequest(request ExportRequest) ([]byte, error) {
+	body, err := buildAPIRequestBody(request)
+	if err != nil {
+		return nil, err
+	}
+	// Source code is full of <, > and &, which should stay readable
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(body); err != nil {
+		return nil, fmt.Errorf("error encoding request body: %v", err)
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// ExportAPIRequest returns the provider request body for the prompt as JSON
+func (a *App) ExportAPIRequest(request ExportRequest) (string, error) {
+	content, err := exportAPIRequest(request)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// SaveAPIRequest asks for a destination and writes the provider request body
+// there, returning the chosen path or "" when the dialog was cancelled
+func (a *App) SaveAPIRequest(request ExportRequest) (string, error) {
+	content, err := exportAPIRequest(request)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Save API Request",
+		DefaultFilename: request.Provider + "-request.json",
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "JSON Files",
+				Pattern:     "*.json",
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error selecting file: %v", err)
+	}
+	if path == "" {
+		return "", nil
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return "", fmt.Errorf("error writing request file: %v", err)
+	}
+	return path, nil
+}
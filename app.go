@@ -10,7 +10,11 @@ import (
 
 // App struct
 type App struct {
-	ctx context.Context
+	ctx     context.Context
+	history historyStore
+	api     localAPI
+	chats   chatSessions
+	vault   credentialVault
 }
 
 // NewApp creates a new App application struct
@@ -26,6 +30,9 @@ func (a *App) LogInfo(message string) {
 // so we can call the runtime methods
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
+	if err := restrictAppDataPermissions(); err != nil {
+		runtime.LogWarning(a.ctx, err.Error())
+	}
 }
 
 // domReady is called after the front-end dom has been loaded
@@ -42,7 +49,9 @@ func (a *App) beforeClose(ctx context.Context) (prevent bool) {
 
 // shutdown is called at application termination
 func (a *App) shutdown(ctx context.Context) {
-	// Perform cleanup tasks here
+	if err := a.StopLocalAPI(); err != nil {
+		runtime.LogWarning(a.ctx, err.Error())
+	}
 }
 
 // getCurrentDirectory returns the current working directory
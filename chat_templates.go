@@ -0,0 +1,82 @@
+package main
+
+import "strings"
+
+// chatTurns is a prompt split into the system and user turns of a chat
+type chatTurns struct {
+	System string
+	User   string
+}
+
+// newChatTurns puts the custom instructions in the system turn and everything
+// else, rendered as Markdown, in the user turn
+func newChatTurns(data *promptData) (chatTurns, error) {
+	userData := *data
+	userData.Instructions = ""
+	user, err := renderMarkdownPrompt(&userData)
+	if err != nil {
+		return chatTurns{}, err
+	}
+	return chatTurns{System: strings.TrimSpace(data.Instructions), User: strings.TrimSpace(user)}, nil
+}
+
+// The templates leave out the BOS token (<s>, <bos>, <|begin_of_text|>).
+// Tokenizers add it themselves when encoding, and a literal one in the text
+// would give the model two.
+
+// mergedUser prepends the system text for templates without a system role
+func (t chatTurns) mergedUser() string {
+	if t.System == "" {
+		return t.User
+	}
+	return t.System + "\n\n" + t.User
+}
+
+// renderChatMLPrompt renders the ChatML template used by Qwen and many fine-tunes
+func renderChatMLPrompt(data *promptData) (string, error) {
+	turns, err := newChatTurns(data)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if turns.System != "" {
+		b.WriteString("<|im_start|>system\n" + turns.System + "<|im_end|>\n")
+	}
+	b.WriteString("<|im_start|>user\n" + turns.User + "<|im_end|>\n")
+	b.WriteString("<|im_start|>assistant\n")
+	return b.String(), nil
+}
+
+// renderLlama3Prompt renders the Llama 3 instruct template
+func renderLlama3Prompt(data *promptData) (string, error) {
+	turns, err := newChatTurns(data)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if turns.System != "" {
+		b.WriteString("<|start_header_id|>system<|end_header_id|>\n\n" + turns.System + "<|eot_id|>")
+	}
+	b.WriteString("<|start_header_id|>user<|end_header_id|>\n\n" + turns.User + "<|eot_id|>")
+	b.WriteString("<|start_header_id|>assistant<|end_header_id|>\n\n")
+	return b.String(), nil
+}
+
+// renderMistralPrompt renders the Mistral instruct template, which has no
+// system role, so the instructions lead the user turn
+func renderMistralPrompt(data *promptData) (string, error) {
+	turns, err := newChatTurns(data)
+	if err != nil {
+		return "", err
+	}
+	return "[INST] " + turns.mergedUser() + " [/INST]", nil
+}
+
+// renderGemmaPrompt renders the Gemma template, which also lacks a system role
+func renderGemmaPrompt(data *promptData) (string, error) {
+	turns, err := newChatTurns(data)
+	if err != nil {
+		return "", err
+	}
+	return "<start_of_turn>user\n" + turns.mergedUser() + "<end_of_turn>\n<start_of_turn>model\n", nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;", "\n", "&#xA;", "\r", "&#xD;", "\t", "&#x9;")
+	// Parsers turn a raw carriage return into a line feed, so CDATA sections
+	// are interrupted to write it as a character reference
+	cdataEscaper = strings.NewReplacer("]]>", "]]]]><![CDATA[>", "\r", "]]>&#xD;<![CDATA[")
+)
+
+// isXMLChar reports whether r may appear in an XML 1.0 document at all,
+// escaped or not
+func isXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		(r >= 0x20 && r <= 0xD7FF) ||
+		(r >= 0xE000 && r <= 0xFFFD) ||
+		(r >= 0x10000 && r <= 0x10FFFF)
+}
+
+// xmlSafe replaces the characters XML cannot represent, such as form feeds
+// and invalid UTF-8, with U+FFFD so the prompt stays parseable
+func xmlSafe(text string) string {
+	if strings.IndexFunc(text, func(r rune) bool { return !isXMLChar(r) }) < 0 && utf8.ValidString(text) {
+		return text
+	}
+	return strings.Map(func(r rune) rune {
+		if !isXMLChar(r) {
+			return utf8.RuneError
+		}
+		return r
+	}, strings.ToValidUTF8(text, string(utf8.RuneError)))
+}
+
+// escapeXMLText escapes text placed between XML tags
+func escapeXMLText(text string) string {
+	return xmlTextEscaper.Replace(xmlSafe(text))
+}
+
+// escapeXMLAttr escapes text placed inside a double quoted XML attribute
+func escapeXMLAttr(text string) string {
+	return xmlAttrEscaper.Replace(xmlSafe(text))
+}
+
+// cdata wraps text in a CDATA section, splitting it wherever the text itself
+// contains the "]]>" terminator or a carriage return
+func cdata(text string) string {
+	return "<![CDATA[" + cdataEscaper.Replace(xmlSafe(text)) + "]]>"
+}
+
+// renderClaudePrompt renders the XML style format
+func renderClaudePrompt(data *promptData) (string, error) {
+	var b strings.Builder
+
+	if data.Note != "" {
+		fmt.Fprintf(&b, "<NOTE>\n%s\n</NOTE>\n\n", escapeXMLText(data.Note))
+	}
+	if data.Tree != "" {
+		fmt.Fprintf(&b, "<PROJECTTREE>%s</PROJECTTREE>\n\n", cdata("\n"+data.Tree+"\n"))
+	}
+	if data.Diff != "" {
+		fmt.Fprintf(&b, "<DIFF>%s</DIFF>\n\n", cdata("\n"+strings.TrimSuffix(data.Diff, "\n")+"\n"))
+	}
+
+	if len(data.Files) > 0 {
+		b.WriteString("<FILES>\n")
+		for _, file := range data.Files {
+			b.WriteString("  <FILE")
+			if data.FileAttributes {
+				if file.Language != "" {
+					fmt.Fprintf(&b, ` language="%s"`, escapeXMLAttr(file.Language))
+				}
+				fmt.Fprintf(&b, ` lines="%d" tokens="%d" truncated="%t"`, file.Lines, estimateTokens(file.Content), file.Excerpt())
+			}
+			fmt.Fprintf(&b, ">\n    <FILEPATH>%s</FILEPATH>\n", escapeXMLText(file.Path))
+			if file.Excerpt() {
+				fmt.Fprintf(&b, "    <FILELINES>%s of %d</FILELINES>\n", formatRanges(file.Ranges), file.Lines)
+			}
+			fmt.Fprintf(&b, "    <FILECONTENT>%s</FILECONTENT>\n  </FILE>\n", cdata(file.Content))
+		}
+		b.WriteString("</FILES>\n\n")
+	}
+
+	if data.Task != "" {
+		fmt.Fprintf(&b, "<TASK>\n%s\n</TASK>\n\n", escapeXMLText(data.Task))
+	}
+	if data.Instructions != "" {
+		fmt.Fprintf(&b, "<INSTRUCTIONS>\n%s\n</INSTRUCTIONS>\n\n", escapeXMLText(data.Instructions))
+	}
+
+	// The raw prompt follows the XML sections as free text
+	b.WriteString(data.RawPrompt)
+	return b.String(), nil
+}
+
+// ParsedPromptFile is a file read back from an XML prompt
+type ParsedPromptFile struct {
+	Path      string `xml:"FILEPATH" json:"path"`
+	Lines     string `xml:"FILELINES" json:"lines,omitempty"`
+	Content   string `xml:"FILECONTENT" json:"content"`
+	Language  string `xml:"language,attr" json:"language,omitempty"`
+	LineCount int    `xml:"lines,attr" json:"lineCount,omitempty"`
+	Tokens    int    `xml:"tokens,attr" json:"tokens,omitempty"`
+	Truncated bool   `xml:"truncated,attr" json:"truncated,omitempty"`
+}
+
+// ParsedPrompt is the content of an XML prompt read back by ParseClaudePrompt
+type ParsedPrompt struct {
+	Note         string             `json:"note,omitempty"`
+	Tree         string             `json:"tree,omitempty"`
+	Diff         string             `json:"diff,omitempty"`
+	Files        []ParsedPromptFile `json:"files"`
+	Task         string             `json:"task,omitempty"`
+	Instructions string             `json:"instructions,omitempty"`
+	RawPrompt    string             `json:"rawPrompt"`
+}
+
+// ParseClaudePrompt reads an XML style prompt back into its parts, failing
+// when the XML sections are malformed
+func (a *App) ParseClaudePrompt(prompt string) (*ParsedPrompt, error) {
+	return parseClaudePrompt(prompt)
+}
+
+// parseClaudePrompt decodes the XML sections in the order renderClaudePrompt
+// writes them; whatever follows the last section is the raw prompt
+func parseClaudePrompt(prompt string) (*ParsedPrompt, error) {
+	parsed := &ParsedPrompt{}
+	rest := prompt
+
+	found := false
+	section := func(name string, target interface{}) error {
+		trimmed := strings.TrimLeft(rest, " \t\r\n")
+		if !strings.HasPrefix(trimmed, "<"+name+">") && !strings.HasPrefix(trimmed, "<"+name+" ") {
+			return nil
+		}
+		decoder := xml.NewDecoder(strings.NewReader(trimmed))
+		decoder.Strict = true
+		if err := decoder.Decode(target); err != nil {
+			return fmt.Errorf("error parsing %s section: %v", name, err)
+		}
+		rest = trimmed[decoder.InputOffset():]
+		found = true
+		return nil
+	}
+
+	var note, tree, diff, task, instructions string
+	var files struct {
+		Files []ParsedPromptFile `xml:"FILE"`
+	}
+	if err := section("NOTE", &note); err != nil {
+		return nil, err
+	}
+	if err := section("PROJECTTREE", &tree); err != nil {
+		return nil, err
+	}
+	if err := section("DIFF", &diff); err != nil {
+		return nil, err
+	}
+	if err := section("FILES", &files); err != nil {
+		return nil, err
+	}
+	if err := section("TASK", &task); err != nil {
+		return nil, err
+	}
+	if err := section("INSTRUCTIONS", &instructions); err != nil {
+		return nil, err
+	}
+
+	parsed.Note = trimNewlines(note)
+	parsed.Tree = trimNewlines(tree)
+	parsed.Diff = trimNewlines(diff)
+	parsed.Files = files.Files
+	parsed.Task = trimNewlines(task)
+	parsed.Instructions = trimNewlines(instructions)
+	// Only the blank line written after the last section is removed, so a
+	// raw prompt starting with line breaks keeps them
+	if found {
+		if trimmed := strings.TrimPrefix(rest, "\r\n\r\n"); trimmed != rest {
+			rest = trimmed
+		} else {
+			rest = strings.TrimPrefix(rest, "\n\n")
+		}
+	}
+	parsed.RawPrompt = rest
+	return parsed, nil
+}
+
+// trimNewlines removes the single line break placed after an opening and
+// before a closing tag
+func trimNewlines(text string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(text, "\n"), "\n")
+}
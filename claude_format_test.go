@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClaudePromptRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		request PromptRequest
+		want    ParsedPrompt
+	}{
+		{
+			name:    "raw prompt only",
+			request: PromptRequest{RawPrompt: "\n\nstarts with line breaks"},
+			want:    ParsedPrompt{RawPrompt: "\n\nstarts with line breaks"},
+		},
+		{
+			name: "all sections",
+			request: PromptRequest{
+				Task:         "Review <this> & that",
+				Instructions: "Be brief",
+				RawPrompt:    "\nleading newline kept",
+				Files:        []SelectedFile{{Path: "a&b.go", Content: "package main\n"}},
+			},
+			want: ParsedPrompt{
+				Files:        []ParsedPromptFile{{Path: "a&b.go", Content: "package main\n"}},
+				Task:         "Review <this> & that",
+				Instructions: "Be brief",
+				RawPrompt:    "\nleading newline kept",
+			},
+		},
+		{
+			name:    "cdata terminator",
+			request: PromptRequest{Files: []SelectedFile{{Path: "x.txt", Content: "a]]>b]]]]>c"}}},
+			want:    ParsedPrompt{Files: []ParsedPromptFile{{Path: "x.txt", Content: "a]]>b]]]]>c"}}},
+		},
+		{
+			name: "carriage returns",
+			request: PromptRequest{
+				Task:  "line one\r\nline two",
+				Files: []SelectedFile{{Path: "win.txt", Content: "one\r\ntwo\rthree\r\n"}},
+			},
+			want: ParsedPrompt{
+				Files: []ParsedPromptFile{{Path: "win.txt", Content: "one\r\ntwo\rthree\r\n"}},
+				Task:  "line one\r\nline two",
+			},
+		},
+		{
+			name: "characters illegal in XML",
+			request: PromptRequest{
+				Task:  "form\ffeed",
+				Files: []SelectedFile{{Path: "ctl\x01.txt", Content: "page\fbreak\x00nul\xffbyte\tok"}},
+			},
+			want: ParsedPrompt{
+				Files: []ParsedPromptFile{{Path: "ctl�.txt", Content: "page�break�nul�byte\tok"}},
+				Task:  "form�feed",
+			},
+		},
+		{
+			name: "attributes and excerpts",
+			request: PromptRequest{
+				FileAttributes: true,
+				Files: []SelectedFile{{
+					Path:    "main.go",
+					Content: "package main\n\nfunc main() {}\n",
+					Ranges:  []LineRange{{Start: 3, End: 3}},
+				}},
+			},
+			want: ParsedPrompt{Files: []ParsedPromptFile{{
+				Path:      "main.go",
+				Lines:     "3 of 3",
+				Content:   "...\n3 | func main() {}",
+				Language:  "go",
+				LineCount: 3,
+				Tokens:    6,
+				Truncated: true,
+			}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.request.Format = "claude"
+			result, err := buildPrompt(test.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := parseClaudePrompt(result.Prompt)
+			if err != nil {
+				t.Fatalf("parsing %q: %v", result.Prompt, err)
+			}
+			if parsed.Files == nil {
+				parsed.Files = []ParsedPromptFile{}
+			}
+			if test.want.Files == nil {
+				test.want.Files = []ParsedPromptFile{}
+			}
+			if !reflect.DeepEqual(*parsed, test.want) {
+				t.Errorf("round trip of %q\ngot  %#v\nwant %#v", result.Prompt, *parsed, test.want)
+			}
+		})
+	}
+}
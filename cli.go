@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// runCLI handles command line sub-commands. It reports false when the
+// arguments are not a sub-command, so the desktop app should start instead.
+func runCLI(args []string, stdout io.Writer) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	switch args[0] {
+	case "export":
+		return true, runExportCommand(args[1:], stdout)
+	case "mcp":
+		return true, serveMCP(os.Stdin, stdout)
+	}
+	return false, nil
+}
+
+// runExportCommand writes a provider API request body built from files
+func runExportCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	provider := flags.String("provider", "anthropic", "provider request format: openai or anthropic")
+	model := flags.String("model", "", "model name placed in the request")
+	format := flags.String("format", defaultExportFormat, "prompt format used for the file and task blocks")
+	taskType := flags.String("task-type", "", "task type label, or the task text itself")
+	instruction := flags.String("instruction", "", "custom instruction label, or the instruction text itself")
+	prompt := flags.String("prompt", "", "task instruction appended after the files")
+	maxTokens := flags.Int("max-tokens", 0, "maximum tokens of the response")
+	cache := flags.Bool("cache", false, "mark the file block for prompt caching")
+	out := flags.String("out", "", "output file, standard output when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	files, err := collectCLIFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	content, err := exportAPIRequest(ExportRequest{
+		Prompt: PromptRequest{
+			Format:       *format,
+			Task:         lookupOptionDescription("task_types.json", *taskType),
+			Instructions: lookupOptionDescription("custom_instructions.json", *instruction),
+			RawPrompt:    *prompt,
+			Files:        files,
+		},
+		Provider:      *provider,
+		Model:         *model,
+		MaxTokens:     *maxTokens,
+		PromptCaching: *cache,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err = fmt.Fprintln(stdout, string(content))
+		return err
+	}
+	if err := os.WriteFile(*out, content, 0644); err != nil {
+		return fmt.Errorf("error writing request file: %v", err)
+	}
+	return nil
+}
+
+// collectCLIFiles expands folder arguments with the default folder options
+func collectCLIFiles(paths []string) ([]SelectedFile, error) {
+	var files []SelectedFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("error getting file info for %s: %v", path, err)
+		}
+		if !info.IsDir() {
+			files = append(files, SelectedFile{Path: path})
+			continue
+		}
+		scan, err := scanFolder(path, defaultFolderConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, warning := range scan.Warnings {
+			fmt.Fprintln(os.Stderr, "warning:", warning)
+		}
+		for _, file := range scan.Files {
+			files = append(files, SelectedFile{Path: file})
+		}
+	}
+	return files, nil
+}
+
+// promptOption is an entry of task_types.json or custom_instructions.json
+type promptOption struct {
+	Label       string `json:"label"`
+	Description string `json:"description"`
+}
+
+// defaultPromptOptions are the lists the UI offers until the user saves
+// their own, so every entry point agrees on them
+var defaultPromptOptions = map[string][]promptOption{
+	"task_types.json": {
+		{Label: "Implement Feature", Description: "Implement a new feature."},
+		{Label: "Fix Bug", Description: "Fix a bug or issue."},
+		{Label: "Refactor Code", Description: "Refactor existing code."},
+	},
+	"custom_instructions.json": {
+		{Label: "Default", Description: "Use default instructions."},
+		{Label: "Detailed", Description: "Provide detailed explanations."},
+		{Label: "Minimal", Description: "Keep explanations brief."},
+	},
+}
+
+// loadPromptOptions reads a task type or custom instruction list, falling
+// back to the defaults of the UI when the file is missing or empty
+func loadPromptOptions(fileName string) ([]promptOption, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return nil, fmt.Errorf("error getting user home directory: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, fileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return append([]promptOption{}, defaultPromptOptions[fileName]...), nil
+		}
+		return nil, fmt.Errorf("error reading %s: %v", fileName, err)
+	}
+	var options []promptOption
+	if err := json.Unmarshal(content, &options); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", fileName, err)
+	}
+	if len(options) == 0 {
+		return append([]promptOption{}, defaultPromptOptions[fileName]...), nil
+	}
+	return options, nil
+}
+
+// lookupOptionDescription resolves a task type or custom instruction label to
+// its description, returning the value unchanged when no label matches
+func lookupOptionDescription(fileName string, value string) string {
+	if value == "" {
+		return ""
+	}
+	options, err := loadPromptOptions(fileName)
+	if err != nil {
+		return value
+	}
+	for _, option := range options {
+		if option.Label == value {
+			return option.Description
+		}
+	}
+	return value
+}
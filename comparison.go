@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// comparisonDoneEvent is emitted with the Comparison once every model answered
+const comparisonDoneEvent = "compare:done"
+
+// CompareRequest sends one prompt to several models. The prompt of each
+// target is replaced by the shared one.
+type CompareRequest struct {
+	Prompt       PromptRequest `json:"prompt"`
+	Targets      []ChatRequest `json:"targets"`
+	ForceRefresh bool          `json:"forceRefresh"`
+}
+
+// ComparisonStart identifies a running comparison and the chat of each
+// target, in the order of the targets
+type ComparisonStart struct {
+	ID      string   `json:"id"`
+	ChatIDs []string `json:"chatIds"`
+}
+
+// ComparisonAnswer is the outcome of one target of a comparison
+type ComparisonAnswer struct {
+	ChatID   string        `json:"chatId"`
+	Provider string        `json:"provider"`
+	Model    string        `json:"model"`
+	Response *ChatResponse `json:"response,omitempty"`
+	Failure  *ChatFailure  `json:"failure,omitempty"`
+	// CostUSD is nil when the price of the model is unknown
+	CostUSD *float64 `json:"costUsd,omitempty"`
+}
+
+// Comparison holds the answers of every model to the same prompt
+type Comparison struct {
+	ID        string             `json:"id"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Answers   []ComparisonAnswer `json:"answers"`
+	// HistoryID identifies the history entry the comparison is stored in
+	HistoryID string `json:"historyId,omitempty"`
+}
+
+// StartComparison sends the prompt to every target concurrently. Each answer
+// streams as chat events under its own chat id and can be cancelled with
+// CancelChat; a compare:done event with the whole comparison follows the last.
+func (a *App) StartComparison(request CompareRequest) (*ComparisonStart, error) {
+	if len(request.Targets) == 0 {
+		return nil, fmt.Errorf("at least one model is required")
+	}
+	if request.Prompt.Format == "" {
+		request.Prompt.Format = defaultExportFormat
+	}
+	targets := make([]ChatRequest, len(request.Targets))
+	for i, target := range request.Targets {
+		if _, err := resolveChatProvider(target.Provider); err != nil {
+			return nil, err
+		}
+		if target.Model == "" {
+			return nil, fmt.Errorf("a model is required for %s", target.Provider)
+		}
+		target.Prompt = request.Prompt
+		target.ForceRefresh = target.ForceRefresh || request.ForceRefresh
+		if target.APIKey == "" && usesDefaultEndpoint(target) {
+			target.APIKey = a.vault.get(target.Provider)
+		}
+		targets[i] = target
+	}
+	// Build once up front so a broken selection fails before any call is made
+	// and every model gets the same files, even when they change meanwhile
+	split, err := buildSplitPrompt(request.Prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &Comparison{
+		ID:        "compare-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		CreatedAt: time.Now().UTC(),
+		Answers:   make([]ComparisonAnswer, len(targets)),
+	}
+	start := &ComparisonStart{ID: comparison.ID}
+	contexts := make([]context.Context, len(targets))
+	for i, target := range targets {
+		id, ctx := a.chats.start(context.Background(), chatTimeout(target))
+		start.ChatIDs = append(start.ChatIDs, id)
+		contexts[i] = ctx
+		comparison.Answers[i] = ComparisonAnswer{ChatID: id, Provider: target.Provider, Model: target.Model}
+	}
+
+	emit := func(event string, data interface{}) {
+		runtime.EventsEmit(a.ctx, event, data)
+	}
+	go func() {
+		a.runComparison(contexts, targets, split, comparison, emit)
+		a.recordComparison(request.Prompt, split, comparison)
+		emit(comparisonDoneEvent, comparison)
+	}()
+	return start, nil
+}
+
+// runComparison sends the prompt to every target at once and fills in the
+// answers, emitting the chat events of each. It returns when all answered.
+func (a *App) runComparison(contexts []context.Context, targets []ChatRequest, split *splitPrompt, comparison *Comparison, emit func(event string, data interface{})) {
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(ctx context.Context, answer *ComparisonAnswer, target ChatRequest) {
+			defer wg.Done()
+			defer a.chats.finish(answer.ChatID)
+			response, err := a.runCachedChat(ctx, target, split, func(text string) {
+				emit(chatDeltaEvent, ChatDelta{ID: answer.ChatID, Text: text})
+			})
+			if err != nil {
+				failure := newChatFailure(answer.ChatID, err)
+				answer.Failure = &failure
+				emit(chatErrorEvent, failure)
+				return
+			}
+			response.ID = answer.ChatID
+			answer.Response = response
+			answer.CostUSD = estimateCost(response)
+			emit(chatDoneEvent, response)
+		}(contexts[i], &comparison.Answers[i], target)
+	}
+	wg.Wait()
+}
+
+// recordComparison stores the prompt the models got with every answer as one
+// history entry. Failures are only logged, the answers were already delivered.
+func (a *App) recordComparison(request PromptRequest, split *splitPrompt, comparison *Comparison) {
+	manifest, err := buildManifest(request)
+	if err != nil {
+		runtime.LogWarning(a.ctx, fmt.Sprintf("Error building prompt manifest: %v", err))
+	}
+	result := &PromptResult{Prompt: split.text(), Tokens: split.tokens(), Manifest: manifest}
+
+	entry, err := a.history.add(request, result, comparison)
+	if err != nil {
+		runtime.LogWarning(a.ctx, fmt.Sprintf("Error saving prompt history: %v", err))
+		return
+	}
+	comparison.HistoryID = entry.ID
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubProvider answers with its model name once every stub of a comparison
+// has started, so the answers only arrive when the calls run concurrently
+type stubProvider struct {
+	started *sync.WaitGroup
+	err     error
+}
+
+func (p stubProvider) defaultBaseURL() string { return "http://stub.invalid" }
+
+func (p stubProvider) streamChat(ctx context.Context, request ChatRequest, split *splitPrompt, onDelta func(text string)) (*ChatResponse, error) {
+	p.started.Done()
+	waited := make(chan struct{})
+	go func() {
+		p.started.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		return nil, fmt.Errorf("the other targets did not start")
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	onDelta(request.Model)
+	return &ChatResponse{Model: request.Model, Content: request.Model, FinishReason: "stop", Usage: ChatUsage{InputTokens: 1000, OutputTokens: 100}}, nil
+}
+
+func TestRunComparison(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var started sync.WaitGroup
+	started.Add(3)
+	chatProviders["stub"] = stubProvider{started: &started}
+	chatProviders["stub-failing"] = stubProvider{started: &started, err: errors.New("stub exploded")}
+	t.Cleanup(func() {
+		delete(chatProviders, "stub")
+		delete(chatProviders, "stub-failing")
+	})
+
+	targets := []ChatRequest{
+		{Provider: "stub", Model: "gpt-4o"},
+		{Provider: "stub", Model: "local-model"},
+		{Provider: "stub-failing", Model: "broken-model"},
+	}
+	split, err := buildSplitPrompt(PromptRequest{Format: defaultExportFormat, RawPrompt: "Say hello."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparison := &Comparison{Answers: make([]ComparisonAnswer, len(targets))}
+	contexts := make([]context.Context, len(targets))
+	for i, target := range targets {
+		contexts[i] = context.Background()
+		comparison.Answers[i] = ComparisonAnswer{ChatID: fmt.Sprintf("chat-%d", i), Provider: target.Provider, Model: target.Model}
+	}
+
+	var mu sync.Mutex
+	events := map[string][]string{}
+	app := &App{}
+	app.runComparison(contexts, targets, split, comparison, func(event string, data interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch data := data.(type) {
+		case ChatDelta:
+			events[data.ID] = append(events[data.ID], event+":"+data.Text)
+		case *ChatResponse:
+			events[data.ID] = append(events[data.ID], event)
+		case ChatFailure:
+			events[data.ID] = append(events[data.ID], event)
+		}
+	})
+
+	for i, answer := range comparison.Answers[:2] {
+		if answer.Failure != nil || answer.Response == nil {
+			t.Fatalf("answer %d: failure = %+v", i, answer.Failure)
+		}
+		if answer.Response.Content != targets[i].Model || answer.Response.ID != answer.ChatID {
+			t.Errorf("answer %d: response = %+v", i, answer.Response)
+		}
+		want := []string{chatDeltaEvent + ":" + targets[i].Model, chatDoneEvent}
+		if fmt.Sprint(events[answer.ChatID]) != fmt.Sprint(want) {
+			t.Errorf("answer %d: events = %v, want %v", i, events[answer.ChatID], want)
+		}
+	}
+	if cost := comparison.Answers[0].CostUSD; cost == nil || *cost <= 0 {
+		t.Errorf("a priced model has no cost: %v", cost)
+	}
+	if cost := comparison.Answers[1].CostUSD; cost != nil {
+		t.Errorf("an unknown model has cost %v", *cost)
+	}
+	failed := comparison.Answers[2]
+	if failed.Response != nil || failed.Failure == nil || !strings.Contains(failed.Failure.Error, "stub exploded") {
+		t.Errorf("failed answer = %+v", failed)
+	}
+	if fmt.Sprint(events[failed.ChatID]) != fmt.Sprint([]string{chatErrorEvent}) {
+		t.Errorf("failed answer events = %v", events[failed.ChatID])
+	}
+}
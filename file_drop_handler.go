@@ -9,6 +9,13 @@ import (
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
+// defaultFolderConfig holds the folder options used for dropped folders
+var defaultFolderConfig = map[string]interface{}{
+	"recursive":      true,
+	"ignoreSuffixes": ".env,.log,.json,.gitignore,.codeprompterignore,.npmrc,.prettierrc",
+	"ignoreFolders":  ".git,.vscode,.idea,node_modules,venv,build,dist,coverage,out,next",
+}
+
 func (a *App) HandleFileDrop(files []string) error {
 	runtime.LogDebug(a.ctx, fmt.Sprintf("Handling file drop for files: %v", files))
 
@@ -49,11 +56,7 @@ func (a *App) processDroppedFiles(files []string) ([]string, error) {
 		}
 
 		if info.IsDir() {
-			folderFiles, err := a.ProcessFolder(fullPath, map[string]interface{}{
-				"recursive":      true,
-				"ignoreSuffixes": ".env,.log,.json,.gitignore,.npmrc,.prettierrc",
-				"ignoreFolders":  ".git,.vscode,.idea,node_modules,venv,build,dist,coverage,out,next",
-			})
+			folderFiles, err := a.ProcessFolder(fullPath, defaultFolderConfig)
 			if err != nil {
 				runtime.LogWarning(a.ctx, fmt.Sprintf("Error processing folder %s: %v", fullPath, err))
 				continue
@@ -68,15 +71,122 @@ func (a *App) processDroppedFiles(files []string) ([]string, error) {
 	return processedFiles, nil
 }
 
+// projectIgnoreFileName holds gitignore style rules for files that belong in
+// the repository but not in prompts
+const projectIgnoreFileName = ".codeprompterignore"
+
+// SkippedPath is a path a folder scan left out, with the reason. Source and
+// Line locate the ignore file rule responsible, when there is one.
+type SkippedPath struct {
+	Path   string `json:"path"`
+	IsDir  bool   `json:"isDir"`
+	Reason string `json:"reason"`
+	Source string `json:"source,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Rule   string `json:"rule,omitempty"`
+}
+
+// FolderScan is the result of scanning a folder
+type FolderScan struct {
+	Files    []string      `json:"files"`
+	Skipped  []SkippedPath `json:"skipped"`
+	Warnings []string      `json:"warnings,omitempty"`
+}
+
 func (a *App) ProcessFolder(folderPath string, config map[string]interface{}) ([]string, error) {
-	var files []string
+	scan, err := a.ScanFolder(folderPath, config)
+	if err != nil {
+		return nil, err
+	}
+	return scan.Files, nil
+}
 
-	recursive, _ := config["recursive"].(bool)
-	ignoreSuffixes, _ := config["ignoreSuffixes"].(string)
-	ignoreFolders, _ := config["ignoreFolders"].(string)
+// ScanFolder lists the files of a folder like ProcessFolder and also reports
+// every path that was skipped and why
+func (a *App) ScanFolder(folderPath string, config map[string]interface{}) (*FolderScan, error) {
+	scan, err := scanFolder(folderPath, config)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range scan.Warnings {
+		runtime.LogWarning(a.ctx, warning)
+	}
+	return scan, nil
+}
+
+// scanRules decide which paths of a folder are left out. The scanner and the
+// project tree share them so both show the same files.
+type scanRules struct {
+	root   string
+	config ProjectConfig
+	ignore *ignoreMatcher
+}
+
+// newScanRules loads the rules of a folder from the folder options,
+// settings.json and .code-prompter.yaml
+func newScanRules(root string, config map[string]interface{}) (*scanRules, []string, error) {
+	base := configLayer{source: "folder options", config: folderConfigLayer(config)}
+	base.config.MaxFileSize = defaultMaxFileSize
+	effective, err := loadEffectiveConfig(root, base)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// .codeprompterignore always applies, .gitignore unless turned off
+	ignoreFiles := []string{".gitignore", projectIgnoreFileName}
+	if respectGitignore, ok := config["respectGitignore"].(bool); ok && !respectGitignore {
+		ignoreFiles = []string{projectIgnoreFileName}
+	}
+	rules := &scanRules{root: root, config: effective.Config, ignore: newIgnoreMatcher(root, ignoreFiles...)}
+	return rules, effective.Warnings, nil
+}
+
+// skip returns why a path below the root is left out, or nil to keep it. The
+// ignore files of its parent folders must have been loaded with loadDir.
+func (r *scanRules) skip(path string, info os.FileInfo) *SkippedPath {
+	skipped := &SkippedPath{Path: path, IsDir: info.IsDir()}
+	rel, _ := filepath.Rel(r.root, path)
+	if rule := r.ignore.match(rel, info.IsDir()); rule != nil {
+		skipped.Reason, skipped.Source, skipped.Line, skipped.Rule = "matched ignore rule", rule.Source, rule.Line, rule.Text
+		return skipped
+	}
+
+	if info.IsDir() {
+		for _, ignoreFolder := range r.config.IgnoreFolders {
+			if strings.HasSuffix(path, ignoreFolder) {
+				skipped.Reason = "ignored folder " + ignoreFolder
+				return skipped
+			}
+		}
+		return nil
+	}
 
-	ignoreSuffixList := strings.Split(ignoreSuffixes, ",")
-	ignoreFolderList := strings.Split(ignoreFolders, ",")
+	for _, ignoreSuffix := range r.config.IgnoreSuffixes {
+		if strings.HasSuffix(path, ignoreSuffix) {
+			skipped.Reason = "ignored suffix " + ignoreSuffix
+			return skipped
+		}
+	}
+	if path == filepath.Join(r.root, projectConfigFileName) {
+		skipped.Reason = "project config file"
+		return skipped
+	}
+	if info.Size() > int64(r.config.MaxFileSize)*1024 {
+		skipped.Reason = fmt.Sprintf("larger than %d KB", r.config.MaxFileSize)
+		return skipped
+	}
+	return nil
+}
+
+// loadDir reads the ignore files of a folder that is kept
+func (r *scanRules) loadDir(dir string) {
+	r.ignore.loadDir(dir)
+}
+
+func scanFolder(folderPath string, config map[string]interface{}) (*FolderScan, error) {
+	scan := &FolderScan{Files: []string{}, Skipped: []SkippedPath{}}
+
+	recursive, _ := config["recursive"].(bool)
 
 	// Get the absolute path of the folder
 	absoluteFolderPath, err := filepath.Abs(folderPath)
@@ -84,34 +194,38 @@ func (a *App) ProcessFolder(folderPath string, config map[string]interface{}) ([
 		return nil, fmt.Errorf("error getting absolute path of folder: %v", err)
 	}
 
+	rules, warnings, err := newScanRules(absoluteFolderPath, config)
+	if err != nil {
+		return nil, err
+	}
+	scan.Warnings = warnings
+
 	err = filepath.Walk(absoluteFolderPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
-			if !recursive && path != folderPath {
-				return filepath.SkipDir
-			}
-			for _, ignoreFolder := range ignoreFolderList {
-				if strings.HasSuffix(path, ignoreFolder) {
-					return filepath.SkipDir
-				}
-			}
+		if path == absoluteFolderPath {
+			rules.loadDir(path)
 			return nil
 		}
-
-		for _, ignoreSuffix := range ignoreSuffixList {
-			if strings.HasSuffix(path, ignoreSuffix) {
-				return nil
+		if info.IsDir() && !recursive {
+			scan.Skipped = append(scan.Skipped, SkippedPath{Path: path, IsDir: true, Reason: "not recursive"})
+			return filepath.SkipDir
+		}
+		if skipped := rules.skip(path, info); skipped != nil {
+			scan.Skipped = append(scan.Skipped, *skipped)
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
 		}
-
-		if info.Size() > 500*1024 {
+		if info.IsDir() {
+			rules.loadDir(path)
 			return nil
 		}
 
-		files = append(files, path)
+		scan.Files = append(scan.Files, path)
 		return nil
 	})
 
@@ -119,5 +233,46 @@ func (a *App) ProcessFolder(folderPath string, config map[string]interface{}) ([
 		return nil, fmt.Errorf("error processing folder: %v", err)
 	}
 
-	return files, nil
+	// Pinned files are always included, even when a rule above skipped them,
+	// but only from inside the scanned folder
+	for _, pinned := range rules.config.PinnedFiles {
+		path, err := resolvePinnedFile(absoluteFolderPath, pinned)
+		if err != nil {
+			scan.Skipped = append(scan.Skipped, SkippedPath{Path: pinned, Reason: err.Error()})
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && !containsString(scan.Files, path) {
+			scan.Files = append(scan.Files, path)
+			for i, skipped := range scan.Skipped {
+				if skipped.Path == path {
+					scan.Skipped = append(scan.Skipped[:i], scan.Skipped[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+
+	return scan, nil
+}
+
+// resolvePinnedFile returns the path of a pinned file, refusing entries that
+// lead outside the root directly or through a symlink
+func resolvePinnedFile(root string, pinned string) (string, error) {
+	if filepath.IsAbs(pinned) || filepath.IsAbs(filepath.FromSlash(pinned)) {
+		return "", fmt.Errorf("pinned file outside the folder")
+	}
+	path := filepath.Join(root, filepath.FromSlash(pinned))
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("error resolving folder: %v", err)
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("pinned file not found")
+	}
+	rel, err := filepath.Rel(realRoot, realPath)
+	if err != nil || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("pinned file outside the folder")
+	}
+	return path, nil
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanFolderRejectsPinnedFilesOutsideRoot(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	parent := t.TempDir()
+	root := filepath.Join(parent, "repo")
+	secret := filepath.Join(parent, "secret")
+	for _, dir := range []string{root, secret} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(secret, "key"):               "private",
+		filepath.Join(root, "main.go"):             "package main",
+		filepath.Join(root, "notes.log"):           "pinned despite the suffix",
+		filepath.Join(root, ".code-prompter.yaml"): "pinnedFiles: [../secret/key, linked/key, notes.log, " + filepath.Join(secret, "key") + "]\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "linked")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+
+	scan, err := scanFolder(root, map[string]interface{}{"recursive": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range scan.Files {
+		if filepath.Base(file) == "key" {
+			t.Errorf("file outside the folder was included: %s", file)
+		}
+	}
+	if !containsString(scan.Files, filepath.Join(root, "notes.log")) {
+		t.Errorf("pinned file inside the folder is missing: %v", scan.Files)
+	}
+	rejected := 0
+	for _, skipped := range scan.Skipped {
+		if skipped.Reason == "pinned file outside the folder" {
+			rejected++
+		}
+	}
+	if rejected != 3 {
+		t.Errorf("rejected %d pinned files, want 3: %+v", rejected, scan.Skipped)
+	}
+}
+
+func TestScanFolderReportsIgnoreRules(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":          "# build output\n*.tmp\nbuild/\n",
+		".codeprompterignore": "docs/\n",
+		"main.go":             "package main",
+		"a.tmp":               "scratch",
+		"build/out.go":        "package build",
+		"docs/guide.md":       "# Guide",
+		"pkg/.gitignore":      "\nsecret.txt\n!keep.tmp\n",
+		"pkg/secret.txt":      "private",
+		"pkg/keep.tmp":        "kept by a negation",
+		"pkg/pkg.go":          "package pkg",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scan, err := scanFolder(root, map[string]interface{}{"recursive": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"main.go", "pkg/pkg.go", "pkg/keep.tmp"} {
+		if !containsString(scan.Files, filepath.Join(root, filepath.FromSlash(name))) {
+			t.Errorf("%s is missing from %v", name, scan.Files)
+		}
+	}
+
+	want := map[string]SkippedPath{
+		"a.tmp":          {IsDir: false, Source: ".gitignore", Line: 2, Rule: "*.tmp"},
+		"build":          {IsDir: true, Source: ".gitignore", Line: 3, Rule: "build/"},
+		"docs":           {IsDir: true, Source: ".codeprompterignore", Line: 1, Rule: "docs/"},
+		"pkg/secret.txt": {IsDir: false, Source: "pkg/.gitignore", Line: 2, Rule: "secret.txt"},
+	}
+	found := map[string]SkippedPath{}
+	for _, skipped := range scan.Skipped {
+		if skipped.Reason != "matched ignore rule" {
+			continue
+		}
+		rel, _ := filepath.Rel(root, skipped.Path)
+		source, _ := filepath.Rel(root, skipped.Source)
+		found[filepath.ToSlash(rel)] = SkippedPath{IsDir: skipped.IsDir, Source: filepath.ToSlash(source), Line: skipped.Line, Rule: skipped.Rule}
+	}
+	if len(found) != len(want) {
+		t.Errorf("skipped by rules = %+v, want %+v", found, want)
+	}
+	for name, expected := range want {
+		if found[name] != expected {
+			t.Errorf("%s: skipped = %+v, want %+v", name, found[name], expected)
+		}
+	}
+}
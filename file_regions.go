@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// excerptEllipsis marks lines left out between selected regions
+const excerptEllipsis = "..."
+
+// LineRange is an inclusive, 1-based range of lines in a file
+type LineRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func (r LineRange) String() string {
+	if r.Start == r.End {
+		return fmt.Sprintf("%d", r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// selectFileRegions resolves the ranges and symbols of a selection against the
+// file content, widens them by the context lines and merges overlapping ones
+func selectFileRegions(file SelectedFile, lines []string) ([]LineRange, error) {
+	var ranges []LineRange
+	for _, r := range file.Ranges {
+		if r.Start < 1 || r.End < r.Start {
+			return nil, fmt.Errorf("invalid line range %d-%d for %s", r.Start, r.End, file.Path)
+		}
+		if r.Start > len(lines) {
+			return nil, fmt.Errorf("line range %s is past the end of %s (%d lines)", r, file.Path, len(lines))
+		}
+		ranges = append(ranges, r)
+	}
+	for _, symbol := range file.Symbols {
+		r, err := findSymbolRange(file.Path, lines, symbol)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+
+	for i := range ranges {
+		ranges[i].Start = max(ranges[i].Start-file.ContextLines, 1)
+		ranges[i].End = min(ranges[i].End+file.ContextLines, len(lines))
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	var merged []LineRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.Start <= merged[n-1].End+1 {
+			merged[n-1].End = max(merged[n-1].End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged, nil
+}
+
+// renderExcerpt prints the selected regions with their original line numbers,
+// separated by an ellipsis wherever lines were left out
+func renderExcerpt(lines []string, ranges []LineRange) string {
+	width := len(fmt.Sprintf("%d", ranges[len(ranges)-1].End))
+
+	var b strings.Builder
+	next := 1
+	for _, r := range ranges {
+		if r.Start > next {
+			b.WriteString(excerptEllipsis + "\n")
+		}
+		for i := r.Start; i <= r.End; i++ {
+			fmt.Fprintf(&b, "%*d | %s\n", width, i, lines[i-1])
+		}
+		next = r.End + 1
+	}
+	if next <= len(lines) {
+		b.WriteString(excerptEllipsis + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// formatRanges renders ranges as a compact list such as "10-40, 82"
+func formatRanges(ranges []LineRange) string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// findSymbolRange locates a named declaration. Go files are parsed exactly;
+// other languages fall back to a definition-keyword heuristic.
+func findSymbolRange(path string, lines []string, symbol string) (LineRange, error) {
+	if strings.EqualFold(filepath.Ext(path), ".go") {
+		if r, ok := findGoSymbolRange(path, strings.Join(lines, "\n"), symbol); ok {
+			return r, nil
+		}
+	} else if r, ok := findGenericSymbolRange(lines, symbol); ok {
+		return r, nil
+	}
+	return LineRange{}, fmt.Errorf("symbol %s not found in %s", symbol, path)
+}
+
+// findGoSymbolRange finds a function, method, type, var or const declaration,
+// including its doc comment. Methods are named Type.Method or (*Type).Method.
+func findGoSymbolRange(path string, content string, symbol string) (LineRange, bool) {
+	fset := token.NewFileSet()
+	// Partially broken files still yield the declarations that did parse
+	file, _ := parser.ParseFile(fset, path, content, parser.ParseComments)
+	if file == nil {
+		return LineRange{}, false
+	}
+
+	receiver, name := "", symbol
+	if i := strings.LastIndex(symbol, "."); i >= 0 {
+		receiver, name = strings.Trim(symbol[:i], "()*"), symbol[i+1:]
+	}
+
+	span := func(doc *ast.CommentGroup, from, to token.Pos) LineRange {
+		if doc != nil {
+			from = doc.Pos()
+		}
+		return LineRange{Start: fset.Position(from).Line, End: fset.Position(to).Line}
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Name.Name == name && receiverName(d) == receiver {
+				return span(d.Doc, d.Pos(), d.End()), true
+			}
+		case *ast.GenDecl:
+			if receiver != "" {
+				continue
+			}
+			for _, spec := range d.Specs {
+				var names []*ast.Ident
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names = []*ast.Ident{s.Name}
+				case *ast.ValueSpec:
+					names = s.Names
+				}
+				for _, ident := range names {
+					if ident.Name != name {
+						continue
+					}
+					// A lone spec owns the whole declaration, grouped specs only themselves
+					if len(d.Specs) == 1 {
+						return span(d.Doc, d.Pos(), d.End()), true
+					}
+					return LineRange{Start: fset.Position(spec.Pos()).Line, End: fset.Position(spec.End()).Line}, true
+				}
+			}
+		}
+	}
+	return LineRange{}, false
+}
+
+// genericDefinition matches common definition forms across languages
+var genericDefinition = `^\s*(?:export\s+)?(?:default\s+)?(?:(?:public|private|protected|internal|static|async|abstract|final|override|pub(?:\([a-z]+\))?|unsafe)\s+)*` +
+	`(?:(?:func|function\*?|def|class|interface|struct|enum|trait|impl|fn|type|module|object|record|const|let|var|val)\s+%[1]s\b` +
+	`|%[1]s\s*[:=]\s*(?:async\s+)?(?:function\b|\([^)]*\)\s*(?::[^=]*)?=>))`
+
+// findGenericSymbolRange finds a definition by keyword and takes its body from
+// the matching braces, or from the indentation for block-by-indent languages
+func findGenericSymbolRange(lines []string, symbol string) (LineRange, bool) {
+	name := symbol
+	if i := strings.LastIndex(symbol, "."); i >= 0 {
+		name = symbol[i+1:]
+	}
+	definition := regexp.MustCompile(fmt.Sprintf(genericDefinition, regexp.QuoteMeta(name)))
+
+	for i, line := range lines {
+		if !definition.MatchString(line) {
+			continue
+		}
+		start := i
+		// Keep decorators, annotations and comments that sit right above
+		for start > 0 {
+			prev := strings.TrimSpace(lines[start-1])
+			if prev == "" || !(strings.HasPrefix(prev, "@") || strings.HasPrefix(prev, "//") || strings.HasPrefix(prev, "#") ||
+				strings.HasPrefix(prev, "*") || strings.HasPrefix(prev, "/*") || strings.HasPrefix(prev, "///")) {
+				break
+			}
+			start--
+		}
+		return LineRange{Start: start + 1, End: blockEnd(lines, i) + 1}, true
+	}
+	return LineRange{}, false
+}
+
+// blockEnd returns the index of the last line of the block opened at line i
+func blockEnd(lines []string, i int) int {
+	if strings.HasSuffix(strings.TrimSpace(lines[i]), ":") {
+		indent := leadingWhitespace(lines[i])
+		end := i
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == "" {
+				continue
+			}
+			if leadingWhitespace(lines[j]) <= indent {
+				break
+			}
+			end = j
+		}
+		return end
+	}
+
+	depth, opened := 0, false
+	for j := i; j < len(lines); j++ {
+		for _, c := range stripQuoted(lines[j]) {
+			switch c {
+			case '{':
+				depth++
+				opened = true
+			case '}':
+				depth--
+			}
+		}
+		if opened && depth <= 0 {
+			return j
+		}
+		// A definition without a body ends on its own line
+		if !opened && j > i+2 {
+			return i
+		}
+	}
+	if !opened {
+		return i
+	}
+	return len(lines) - 1
+}
+
+func leadingWhitespace(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// stripQuoted blanks out string and character literals on a line so braces
+// inside them are not counted
+func stripQuoted(line string) string {
+	var b strings.Builder
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
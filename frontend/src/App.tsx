@@ -14,10 +14,13 @@ import {
   WriteTaskTypesFile,
   ReadCustomInstructionsFile,
   WriteCustomInstructionsFile,
+  BuildPrompt,
 } from '../wailsjs/go/main/App';
+import { main } from '../wailsjs/go/models';
+import { EventsOn } from '../wailsjs/runtime/runtime';
 import TaskTypeEditModal from './components/TaskTypeEditModal';
 import CustomInstructionsEditModal from './components/CustomInstructionsEditModal';
-import { TaskTypeOption, CustomInstructionOption } from './types';
+import { TaskTypeOption, CustomInstructionOption, APISelection } from './types';
 import { v4 as uuidv4 } from 'uuid';
 import { CheckedState } from '@radix-ui/react-checkbox';
 
@@ -35,6 +38,9 @@ function App() {
   const [taskTypeOptions, setTaskTypeOptions] = useState<TaskTypeOption[]>([]);
   const [customInstructionsOptions, setCustomInstructionsOptions] = useState<CustomInstructionOption[]>([]);
   const [tokenCount, setTokenCount] = useState<number>(0);
+  // The selection pushed through the local API. While set, the final prompt
+  // is built by the backend so line ranges, symbols and every format apply.
+  const [apiSelection, setApiSelection] = useState<APISelection | null>(null);
 
   // State to track the current prompt type ('ChatGPT' or 'Claude')
   const [currentPromptType, setCurrentPromptType] = useState<'ChatGPT' | 'Claude'>('ChatGPT');
@@ -100,6 +106,55 @@ function App() {
     loadOptions();
   }, []);
 
+  // Apply the selection editors and scripts push through the local API
+  useEffect(() => {
+    return EventsOn('api:selection', (selection: APISelection) => {
+      // The API sends the descriptions, the selectors hold the labels
+      const taskOption = taskTypeOptions.find((opt) => opt.description === selection.task);
+      if (taskOption) {
+        setTaskType(taskOption.label);
+        setTaskTypeChecked(true);
+      }
+      const instructionOption = customInstructionsOptions.find((opt) => opt.description === selection.instructions);
+      if (instructionOption) {
+        setCustomInstructions(instructionOption.label);
+        setCustomInstructionsChecked(true);
+      }
+
+      const format = (selection.format || '').toLowerCase();
+      if (format === 'chatgpt') {
+        setCurrentPromptType('ChatGPT');
+      } else if (format === 'claude') {
+        setCurrentPromptType('Claude');
+      }
+      setApiSelection(selection);
+    });
+  }, [taskTypeOptions, customInstructionsOptions]);
+
+  // Build the API selection with the backend. The raw prompt is read when the
+  // selection arrives or a Generate button is clicked, not on every keystroke,
+  // since each build is recorded in the prompt history.
+  useEffect(() => {
+    if (!apiSelection) {
+      return;
+    }
+    BuildPrompt(
+      main.PromptRequest.createFrom({
+        format: apiSelection.format || 'chatgpt',
+        task: apiSelection.task,
+        instructions: apiSelection.instructions,
+        rawPrompt,
+        files: apiSelection.files || [],
+      })
+    )
+      .then((result) => {
+        setFinalPrompt(result.prompt);
+        setTokenCount(result.tokens);
+      })
+      .catch((error) => console.error('Error building the API selection:', error));
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [apiSelection]);
+
   const handleSaveTaskTypes = async (options: TaskTypeOption[]) => {
     try {
       await WriteTaskTypesFile(JSON.stringify(options, null, 2));
@@ -229,6 +284,9 @@ function App() {
 
   // useEffect to handle prompt generation and default task instruction
   useEffect(() => {
+    if (apiSelection) {
+      return;
+    }
     // Update task instruction if it matches the default of the previous prompt type
     const prevDefaultInstruction =
       prevPromptType.current === 'ChatGPT'
@@ -265,6 +323,7 @@ function App() {
     customInstructionsOptions,
     generateChatGPTPrompt,
     generateClaudePrompt,
+    apiSelection,
   ]);
 
   const handleCopy = () => {
@@ -274,18 +333,22 @@ function App() {
       .catch((err) => console.error('Failed to copy prompt: ', err));
   };
 
+  // Picking files in the UI replaces the API selection
   const handleSelectedFilesChange = useCallback((files: SelectedFile[]) => {
     setSelectedFilesArray(files);
+    setApiSelection(null);
   }, []);
 
   // Handle Generate ChatGPT button click
   const handleGenerateChatGPT = () => {
     setCurrentPromptType('ChatGPT');
+    setApiSelection((selection) => selection && { ...selection, format: 'chatgpt' });
   };
 
   // Handle Generate Claude button click
   const handleGenerateClaude = () => {
     setCurrentPromptType('Claude');
+    setApiSelection((selection) => selection && { ...selection, format: 'claude' });
   };
 
   return (
@@ -11,4 +11,22 @@ export interface CustomInstructionOption {
     label: string;
     description: string;
 }
-  
\ No newline at end of file
+
+// APISelectionFile is a file of the API selection, optionally narrowed to
+// line ranges or symbols
+export interface APISelectionFile {
+    path: string;
+    content?: string;
+    ranges?: { start: number; end: number }[];
+    symbols?: string[];
+    contextLines?: number;
+}
+
+// APISelection is the selection pushed through the local API, sent with the
+// api:selection event
+export interface APISelection {
+    files: APISelectionFile[];
+    task: string;
+    instructions: string;
+    format: string;
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	historyFileName        = "history.jsonl"
+	defaultHistoryPageSize = 20
+	historyPreviewLength   = 200
+)
+
+// HistoryEntry is a generated prompt together with what produced it
+type HistoryEntry struct {
+	ID          string          `json:"id"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	Format      string          `json:"format"`
+	TaskType    string          `json:"taskType"`
+	Instruction string          `json:"instruction"`
+	Files       []string        `json:"files"`
+	Tokens      int             `json:"tokens"`
+	Pinned      bool            `json:"pinned"`
+	Prompt      string          `json:"prompt"`
+	Request     PromptRequest   `json:"request"`
+	Manifest    *PromptManifest `json:"manifest,omitempty"`
+	// Comparison holds the answers of several models when the prompt was
+	// sent to them with StartComparison
+	Comparison *Comparison `json:"comparison,omitempty"`
+}
+
+// HistorySummary is a history entry without the prompt text, for listings
+type HistorySummary struct {
+	ID          string    `json:"id"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Format      string    `json:"format"`
+	TaskType    string    `json:"taskType"`
+	Instruction string    `json:"instruction"`
+	Files       []string  `json:"files"`
+	Tokens      int       `json:"tokens"`
+	Pinned      bool      `json:"pinned"`
+	Preview     string    `json:"preview"`
+	// Models lists the compared models of a comparison
+	Models []string `json:"models,omitempty"`
+}
+
+// HistoryPage is one page of history search results, pinned entries first
+// and then newest first
+type HistoryPage struct {
+	Entries  []HistorySummary `json:"entries"`
+	Total    int              `json:"total"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"pageSize"`
+}
+
+// HistoryRestore is the selection state of a history entry. Missing lists the
+// selected files that no longer exist on disk.
+type HistoryRestore struct {
+	Request PromptRequest `json:"request"`
+	Missing []string      `json:"missing"`
+}
+
+// historyRecord is one line of the append-only history file: either a new
+// entry or a change of the pinned flag of an earlier one
+type historyRecord struct {
+	Op     string        `json:"op"`
+	Entry  *HistoryEntry `json:"entry,omitempty"`
+	ID     string        `json:"id,omitempty"`
+	Pinned bool          `json:"pinned,omitempty"`
+}
+
+// historyStore keeps the history in memory with an inverted index from
+// words to entry ids, loaded from disk on first use
+type historyStore struct {
+	mu      sync.Mutex
+	loaded  bool
+	entries map[string]*HistoryEntry
+	index   map[string]map[string]bool
+}
+
+// historyPath returns the history file
+func historyPath() (string, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting user home directory: %v", err)
+	}
+	return filepath.Join(dir, historyFileName), nil
+}
+
+// load replays the history file once
+func (h *historyStore) load() error {
+	if h.loaded {
+		return nil
+	}
+	h.entries = map[string]*HistoryEntry{}
+	h.index = map[string]map[string]bool{}
+
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			h.loaded = true
+			return nil
+		}
+		return fmt.Errorf("error reading history file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 256*1024*1024)
+	for scanner.Scan() {
+		var record historyRecord
+		// A line cut short by a crash is skipped rather than losing the history
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		h.apply(&record)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading history file: %v", err)
+	}
+	h.loaded = true
+	return nil
+}
+
+// apply updates the in-memory state with one record
+func (h *historyStore) apply(record *historyRecord) {
+	switch record.Op {
+	case "add":
+		if record.Entry == nil {
+			return
+		}
+		h.entries[record.Entry.ID] = record.Entry
+		for _, word := range historyWords(record.Entry) {
+			if h.index[word] == nil {
+				h.index[word] = map[string]bool{}
+			}
+			h.index[word][record.Entry.ID] = true
+		}
+	case "pin":
+		if entry, ok := h.entries[record.ID]; ok {
+			entry.Pinned = record.Pinned
+		}
+	}
+}
+
+// appendRecord writes a record to the end of the history file and applies it
+func (h *historyStore) appendRecord(record *historyRecord) error {
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding history record: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), privateDirMode); err != nil {
+		return fmt.Errorf("error creating history directory: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, privateFileMode)
+	if err != nil {
+		return fmt.Errorf("error opening history file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing history file: %v", err)
+	}
+	h.apply(record)
+	return nil
+}
+
+// add stores a generated prompt, with the answers when it was a comparison,
+// and returns its entry
+func (h *historyStore) add(request PromptRequest, result *PromptResult, comparison *Comparison) (*HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	entry := &HistoryEntry{
+		ID:          strconv.FormatInt(now.UnixNano(), 36),
+		CreatedAt:   now,
+		Format:      request.Format,
+		TaskType:    request.Task,
+		Instruction: request.Instructions,
+		Tokens:      result.Tokens,
+		Prompt:      result.Prompt,
+		Request:     request,
+		Manifest:    result.Manifest,
+	}
+	// File contents are already in the prompt, the request only keeps the selection
+	entry.Request.Files = make([]SelectedFile, len(request.Files))
+	for i, file := range request.Files {
+		file.Content = ""
+		entry.Request.Files[i] = file
+		entry.Files = append(entry.Files, file.Path)
+	}
+	for h.entries[entry.ID] != nil {
+		entry.ID += "0"
+	}
+	// The entry keeps its own copy of the comparison, saved with its id
+	if comparison != nil {
+		stored := *comparison
+		stored.Answers = append([]ComparisonAnswer(nil), comparison.Answers...)
+		stored.HistoryID = entry.ID
+		entry.Comparison = &stored
+	}
+
+	if err := h.appendRecord(&historyRecord{Op: "add", Entry: entry}); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// historyWords returns the distinct lower case words of an entry
+func historyWords(entry *HistoryEntry) []string {
+	fields := append([]string{entry.Prompt, entry.Format, entry.TaskType, entry.Instruction}, entry.Files...)
+	if entry.Comparison != nil {
+		for _, answer := range entry.Comparison.Answers {
+			fields = append(fields, answer.Model)
+			if answer.Response != nil {
+				fields = append(fields, answer.Response.Content)
+			}
+		}
+	}
+	text := strings.Join(fields, " ")
+	seen := map[string]bool{}
+	var words []string
+	for _, word := range tokenizeHistory(text) {
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// tokenizeHistory splits text into lower case runs of letters and digits
+func tokenizeHistory(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// search returns the entries containing every word of the query
+func (h *historyStore) search(query string, page int, pageSize int) (*HistoryPage, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+
+	var matches []*HistoryEntry
+	words := tokenizeHistory(query)
+	if len(words) == 0 {
+		for _, entry := range h.entries {
+			matches = append(matches, entry)
+		}
+	} else {
+		// Start from the rarest word so the candidate set is smallest
+		sort.Slice(words, func(i, j int) bool {
+			return len(h.index[words[i]]) < len(h.index[words[j]])
+		})
+		for id := range h.index[words[0]] {
+			found := true
+			for _, word := range words[1:] {
+				if !h.index[word][id] {
+					found = false
+					break
+				}
+			}
+			if found {
+				matches = append(matches, h.entries[id])
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Pinned != matches[j].Pinned {
+			return matches[i].Pinned
+		}
+		return matches[i].CreatedAt.After(matches[j].CreatedAt)
+	})
+
+	if pageSize <= 0 {
+		pageSize = defaultHistoryPageSize
+	}
+	if page < 1 {
+		page = 1
+	}
+	result := &HistoryPage{Entries: []HistorySummary{}, Total: len(matches), Page: page, PageSize: pageSize}
+	start := (page - 1) * pageSize
+	for i := start; i < len(matches) && i < start+pageSize; i++ {
+		result.Entries = append(result.Entries, summarizeHistoryEntry(matches[i]))
+	}
+	return result, nil
+}
+
+func summarizeHistoryEntry(entry *HistoryEntry) HistorySummary {
+	preview := []rune(entry.Prompt)
+	if len(preview) > historyPreviewLength {
+		preview = preview[:historyPreviewLength]
+	}
+	summary := HistorySummary{
+		ID:          entry.ID,
+		CreatedAt:   entry.CreatedAt,
+		Format:      entry.Format,
+		TaskType:    entry.TaskType,
+		Instruction: entry.Instruction,
+		Files:       entry.Files,
+		Tokens:      entry.Tokens,
+		Pinned:      entry.Pinned,
+		Preview:     string(preview),
+	}
+	if entry.Comparison != nil {
+		for _, answer := range entry.Comparison.Answers {
+			summary.Models = append(summary.Models, answer.Provider+"/"+answer.Model)
+		}
+	}
+	return summary
+}
+
+// get returns a copy of an entry
+func (h *historyStore) get(id string) (*HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	entry, ok := h.entries[id]
+	if !ok {
+		return nil, fmt.Errorf("history entry %s not found", id)
+	}
+	copied := *entry
+	return &copied, nil
+}
+
+// setPinned records a change of the pinned flag
+func (h *historyStore) setPinned(id string, pinned bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.load(); err != nil {
+		return err
+	}
+	if _, ok := h.entries[id]; !ok {
+		return fmt.Errorf("history entry %s not found", id)
+	}
+	return h.appendRecord(&historyRecord{Op: "pin", ID: id, Pinned: pinned})
+}
+
+// SearchHistory returns a page of the prompts containing every word of the
+// query, or of all prompts when the query is empty. Pages start at 1.
+func (a *App) SearchHistory(query string, page int, pageSize int) (*HistoryPage, error) {
+	return a.history.search(query, page, pageSize)
+}
+
+// GetHistoryEntry returns a stored prompt with its full text
+func (a *App) GetHistoryEntry(id string) (*HistoryEntry, error) {
+	return a.history.get(id)
+}
+
+// PinHistoryEntry pins or unpins a stored prompt
+func (a *App) PinHistoryEntry(id string, pinned bool) error {
+	return a.history.setPinned(id, pinned)
+}
+
+// RestoreFromHistory returns the request a stored prompt was built from, so
+// the selection, format and instructions can be set up again
+func (a *App) RestoreFromHistory(id string) (*HistoryRestore, error) {
+	entry, err := a.history.get(id)
+	if err != nil {
+		return nil, err
+	}
+	restore := &HistoryRestore{Request: entry.Request, Missing: []string{}}
+	for _, file := range entry.Request.Files {
+		if _, err := os.Stat(file.Path); err != nil {
+			restore.Missing = append(restore.Missing, file.Path)
+		}
+	}
+	return restore, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHistoryStoresComparisonWithItsID(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	comparison := &Comparison{ID: "compare-1", Answers: []ComparisonAnswer{{ChatID: "chat-1", Provider: "openai", Model: "gpt-4o"}}}
+	var history historyStore
+	entry, err := history.add(PromptRequest{Format: defaultExportFormat}, &PromptResult{Prompt: "prompt"}, comparison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Comparison == comparison || entry.Comparison.HistoryID != entry.ID {
+		t.Errorf("the entry shares the comparison or lacks its id: %+v", entry.Comparison)
+	}
+	comparison.Answers[0].Model = "changed"
+
+	// The saved file has the id as well
+	var reloaded historyStore
+	saved, err := reloaded.get(entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Comparison == nil || saved.Comparison.HistoryID != entry.ID || saved.Comparison.Answers[0].Model != "gpt-4o" {
+		t.Errorf("unexpected saved comparison: %+v", saved.Comparison)
+	}
+	if stored, _ := history.get(entry.ID); stored.Comparison.Answers[0].Model != "gpt-4o" {
+		t.Errorf("changing the comparison changed the stored entry")
+	}
+}
+
+func TestHistorySearchPinAndReload(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	kept := filepath.Join(root, "kept.go")
+	if err := os.WriteFile(kept, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	removed := filepath.Join(root, "removed.go")
+
+	app := &App{}
+	var ids []string
+	for _, prompt := range []string{"Refactor the parser", "Fix the PARSER crash", "Document the lexer"} {
+		request := PromptRequest{Format: "markdown", Task: "Fix a bug.", Files: []SelectedFile{{Path: kept, Content: "package main\n"}, {Path: removed}}}
+		entry, err := app.history.add(request, &PromptResult{Prompt: prompt, Tokens: 5}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, entry.ID)
+	}
+	search := func(history *historyStore, query string, page int, pageSize int) *HistoryPage {
+		t.Helper()
+		result, err := history.search(query, page, pageSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	resultIDs := func(result *HistoryPage) string {
+		var found []string
+		for _, entry := range result.Entries {
+			found = append(found, entry.ID)
+		}
+		return strings.Join(found, ",")
+	}
+
+	// Every word must match, in any case; the newest entry comes first
+	for _, test := range []struct {
+		query string
+		want  []string
+	}{
+		{"parser", []string{ids[1], ids[0]}},
+		{"Parser crash", []string{ids[1]}},
+		{"lexer parser", nil},
+		{"kept.go", []string{ids[2], ids[1], ids[0]}},
+		{"", []string{ids[2], ids[1], ids[0]}},
+	} {
+		if got := resultIDs(search(&app.history, test.query, 1, 10)); got != strings.Join(test.want, ",") {
+			t.Errorf("search %q = %s, want %s", test.query, got, strings.Join(test.want, ","))
+		}
+	}
+	if result := search(&app.history, "", 2, 2); result.Total != 3 || resultIDs(result) != ids[0] {
+		t.Errorf("second page = %s of %d", resultIDs(result), result.Total)
+	}
+
+	// Pinned entries come first until unpinned
+	if err := app.PinHistoryEntry(ids[0], true); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.PinHistoryEntry(ids[1], true); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.PinHistoryEntry(ids[1], false); err != nil {
+		t.Fatal(err)
+	}
+	if got := resultIDs(search(&app.history, "", 1, 10)); got != strings.Join([]string{ids[0], ids[2], ids[1]}, ",") {
+		t.Errorf("pinned order = %s", got)
+	}
+	if err := app.PinHistoryEntry("missing", true); err == nil {
+		t.Error("pinning a missing entry did not fail")
+	}
+
+	// A new store replays history.jsonl, skipping a line cut short by a crash
+	path, err := historyPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"op":"add","entry":{"id":"cut`)
+	file.Close()
+	var reloaded historyStore
+	if got := resultIDs(search(&reloaded, "", 1, 10)); got != strings.Join([]string{ids[0], ids[2], ids[1]}, ",") {
+		t.Errorf("reloaded order = %s", got)
+	}
+	if got := resultIDs(search(&reloaded, "crash", 1, 10)); got != ids[1] {
+		t.Errorf("reloaded index search = %s", got)
+	}
+
+	// Restoring returns the selection without contents and the missing files
+	restore, err := app.RestoreFromHistory(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restore.Request.Format != "markdown" || restore.Request.Task != "Fix a bug." || len(restore.Request.Files) != 2 || restore.Request.Files[0].Content != "" {
+		t.Errorf("unexpected restored request: %+v", restore.Request)
+	}
+	if len(restore.Missing) != 1 || restore.Missing[0] != removed {
+		t.Errorf("missing = %v, want %s", restore.Missing, removed)
+	}
+	if _, err := app.RestoreFromHistory("missing"); err == nil {
+		t.Error("restoring a missing entry did not fail")
+	}
+}
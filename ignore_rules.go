@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is one pattern line of an ignore file
+type ignoreRule struct {
+	// base is the slash separated directory of the ignore file, relative to the root
+	base    string
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	Source  string
+	Line    int
+	Text    string
+}
+
+// ignoreMatcher applies gitignore style rules found while walking a tree.
+// Ignore files are loaded per directory, so rules only apply below the
+// directory that holds them and deeper files take precedence.
+type ignoreMatcher struct {
+	root      string
+	fileNames []string
+	rules     []*ignoreRule
+	loaded    map[string]bool
+}
+
+// newIgnoreMatcher creates a matcher for root reading the given ignore file names
+func newIgnoreMatcher(root string, fileNames ...string) *ignoreMatcher {
+	return &ignoreMatcher{root: root, fileNames: fileNames, loaded: map[string]bool{}}
+}
+
+// loadDir reads the ignore files of a directory inside the root, once
+func (m *ignoreMatcher) loadDir(dir string) {
+	if m.loaded[dir] {
+		return
+	}
+	m.loaded[dir] = true
+
+	base, err := filepath.Rel(m.root, dir)
+	if err != nil {
+		return
+	}
+	base = filepath.ToSlash(base)
+	if base == "." {
+		base = ""
+	}
+
+	for _, name := range m.fileNames {
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if rule := parseIgnoreRule(scanner.Text()); rule != nil {
+				rule.base = base
+				rule.Source = filepath.Join(dir, name)
+				rule.Line = line
+				m.rules = append(m.rules, rule)
+			}
+		}
+		file.Close()
+	}
+}
+
+// match returns the rule deciding that the root relative path is ignored,
+// or nil when it is not ignored
+func (m *ignoreMatcher) match(rel string, isDir bool) *ignoreRule {
+	rel = filepath.ToSlash(rel)
+	var decided *ignoreRule
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		target := rel
+		if rule.base != "" {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			target = rel[len(rule.base)+1:]
+		}
+		if rule.pattern.MatchString(target) {
+			decided = rule
+		}
+	}
+	if decided == nil || decided.negate {
+		return nil
+	}
+	return decided
+}
+
+// parseIgnoreRule converts one gitignore line into a rule, or nil for blank
+// lines and comments
+func parseIgnoreRule(line string) *ignoreRule {
+	text := strings.TrimRight(line, " \t\r")
+	if strings.HasSuffix(line, `\ `) {
+		text += " "
+	}
+	if text == "" || strings.HasPrefix(text, "#") {
+		return nil
+	}
+
+	rule := &ignoreRule{Text: text}
+	pattern := text
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	if pattern == "" {
+		return nil
+	}
+
+	// Patterns without an inner slash match a name at any depth
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	expr := globToRegexp(pattern)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	compiled, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil
+	}
+	rule.pattern = compiled
+	return rule
+}
+
+// globToRegexp translates gitignore glob syntax, including **, to a regular expression
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// isAlwaysIgnored reports file names that are never useful in a prompt
+func isAlwaysIgnored(name string) bool {
+	return name == ".git"
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// IncrementalDiff sends changed files as unified diffs
+	IncrementalDiff = "diff"
+	// IncrementalFull sends changed files in full
+	IncrementalFull = "full"
+
+	workspaceSnapshotExtension = ".snapshot"
+)
+
+// IncrementalResult is a prompt holding only what changed since the previous
+// prompt of a workspace
+type IncrementalResult struct {
+	Result *PromptResult `json:"result"`
+	// Changed files were sent before and differ now
+	Changed []string `json:"changed"`
+	// Added files were not part of any earlier prompt
+	Added []string `json:"added"`
+	// Unchanged files were sent before and left out this time
+	Unchanged []string `json:"unchanged"`
+}
+
+// snapshotFile is a file as it was sent in a previous prompt, i.e. its
+// excerpt or minified text when only that was sent
+type snapshotFile struct {
+	SHA256  string `json:"sha256"`
+	Content string `json:"content"`
+}
+
+// workspaceSnapshotPath returns the file holding the last sent version of
+// the files of a workspace
+func workspaceSnapshotPath(name string) (string, error) {
+	path, err := workspaceFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(path, workspaceExtension) + workspaceSnapshotExtension, nil
+}
+
+// loadWorkspaceSnapshot reads the files sent so far, empty when none were
+func loadWorkspaceSnapshot(name string) (map[string]snapshotFile, error) {
+	snapshot := map[string]snapshotFile{}
+	path, err := workspaceSnapshotPath(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return snapshot, nil
+		}
+		return nil, fmt.Errorf("error reading workspace snapshot: %v", err)
+	}
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("error parsing workspace snapshot: %v", err)
+	}
+	return snapshot, nil
+}
+
+func saveWorkspaceSnapshot(name string, snapshot map[string]snapshotFile) error {
+	path, err := workspaceSnapshotPath(name)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("error encoding workspace snapshot: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), privateDirMode); err != nil {
+		return fmt.Errorf("error creating workspaces directory: %v", err)
+	}
+	if err := os.WriteFile(path, content, privateFileMode); err != nil {
+		return fmt.Errorf("error writing workspace snapshot: %v", err)
+	}
+	return nil
+}
+
+// BuildIncrementalPrompt builds a prompt with only the files that changed
+// since the previous incremental prompt of the workspace, either as unified
+// diffs or in full, and remembers the files of this prompt for the next one;
+// files left out of it count as new when they are selected again. A note ahead
+// of the files tells the model which earlier files are unchanged.
+func (a *App) BuildIncrementalPrompt(workspace string, request PromptRequest, mode string) (*IncrementalResult, error) {
+	if mode != IncrementalDiff && mode != IncrementalFull {
+		return nil, fmt.Errorf("unknown incremental mode: %s", mode)
+	}
+	snapshot, err := loadWorkspaceSnapshot(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	incremental := &IncrementalResult{Changed: []string{}, Added: []string{}, Unchanged: []string{}}
+	current := map[string]snapshotFile{}
+	var diffs []string
+	files := request.Files
+	request.Files = nil
+	for _, file := range files {
+		// Compare and remember the text the model gets, not the file on disk
+		prepared, err := preparePromptFile(file, request.Minify)
+		if err != nil {
+			return nil, err
+		}
+		content := prepared.Content
+		hash := hashContent(content)
+		previous, sent := snapshot[file.Path]
+		current[file.Path] = snapshotFile{SHA256: hash, Content: content}
+
+		switch {
+		case !sent:
+			incremental.Added = append(incremental.Added, file.Path)
+		case previous.SHA256 == hash:
+			incremental.Unchanged = append(incremental.Unchanged, file.Path)
+			continue
+		default:
+			incremental.Changed = append(incremental.Changed, file.Path)
+			if mode == IncrementalDiff {
+				// Files rewritten beyond a useful diff are sent in full
+				if diff, ok := unifiedDiff(file.Path, previous.Content, content); ok {
+					diffs = append(diffs, diff)
+					continue
+				}
+			}
+		}
+		request.Files = append(request.Files, file)
+	}
+
+	if len(diffs) > 0 {
+		request.Diff = strings.TrimSuffix(strings.Join(append(diffs, request.Diff), ""), "\n")
+	}
+	if header := incrementalHeader(incremental.Unchanged, len(diffs) > 0); header != "" {
+		request.Note = strings.TrimSpace(header + "\n\n" + request.Note)
+	}
+
+	result, err := buildPrompt(request)
+	if err != nil {
+		return nil, err
+	}
+	a.recordPrompt(request, result)
+	incremental.Result = result
+
+	if err := saveWorkspaceSnapshot(workspace, current); err != nil {
+		return nil, err
+	}
+	return incremental, nil
+}
+
+// incrementalHeader explains to the model what was left out of the prompt
+func incrementalHeader(unchanged []string, hasDiffs bool) string {
+	var b strings.Builder
+	if len(unchanged) > 0 {
+		b.WriteString("These files from the previous prompt are unchanged and not repeated:\n")
+		for _, path := range unchanged {
+			b.WriteString("- " + path + "\n")
+		}
+	}
+	if hasDiffs {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("Files changed since the previous prompt are given as unified diffs against the version sent then.\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// removeWorkspaceSnapshot deletes the files sent for a workspace, if any
+func removeWorkspaceSnapshot(name string) error {
+	path, err := workspaceSnapshotPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error deleting workspace snapshot: %v", err)
+	}
+	return nil
+}
+
+// ResetIncrementalBaseline forgets the files sent for a workspace, so the
+// next incremental prompt includes every file again
+func (a *App) ResetIncrementalBaseline(workspace string) error {
+	return removeWorkspaceSnapshot(workspace)
+}
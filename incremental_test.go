@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildIncrementalPrompt(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	first, second := filepath.Join(root, "first.go"), filepath.Join(root, "second.go")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(first, "package main\n\n// greet says hello\nfunc greet() {}\n")
+	write(second, "package main\n\nfunc main() {}\n")
+
+	app := &App{}
+	request := PromptRequest{
+		Format:    "claude",
+		RawPrompt: "Review the changes.",
+		Files:     []SelectedFile{{Path: first}, {Path: second}},
+		Minify:    &MinifyOptions{StripComments: true},
+	}
+	if _, err := app.BuildIncrementalPrompt("work", request, IncrementalFull); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshot holds the minified text that was sent
+	snapshot, err := loadWorkspaceSnapshot("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := snapshot[first].Content; strings.Contains(content, "greet says hello") || !strings.Contains(content, "func greet()") {
+		t.Errorf("snapshot is not the text sent: %q", content)
+	}
+
+	// A comment the model never saw is no change
+	write(first, "package main\n\n// greet says hi\nfunc greet() {}\n")
+	write(second, "package main\n\nfunc main() { greet() }\n")
+	incremental, err := app.BuildIncrementalPrompt("work", request, IncrementalFull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incremental.Unchanged) != 1 || incremental.Unchanged[0] != first || len(incremental.Changed) != 1 || incremental.Changed[0] != second {
+		t.Errorf("unchanged = %v, changed = %v", incremental.Unchanged, incremental.Changed)
+	}
+
+	// The note comes ahead of the files and the task stays last
+	prompt := incremental.Result.Prompt
+	note, files := strings.Index(prompt, "<NOTE>"), strings.Index(prompt, "<FILES>")
+	if note < 0 || files < 0 || note > files || !strings.Contains(prompt[note:files], "- "+first) {
+		t.Errorf("the note does not precede the files:\n%s", prompt)
+	}
+	if !strings.HasSuffix(prompt, "Review the changes.") {
+		t.Errorf("the raw prompt is not last:\n%s", prompt)
+	}
+	parsed, err := parseClaudePrompt(prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(parsed.Note, "unchanged") || parsed.RawPrompt != "Review the changes." || len(parsed.Files) != 1 {
+		t.Errorf("unexpected parsed prompt: %+v", parsed)
+	}
+}
+
+func TestIncrementalSnapshotHoldsTheLastPrompt(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	first, second := filepath.Join(root, "first.go"), filepath.Join(root, "second.go")
+	for _, path := range []string{first, second} {
+		if err := os.WriteFile(path, []byte("package main\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := &App{}
+	build := func(paths ...string) *IncrementalResult {
+		t.Helper()
+		request := PromptRequest{Format: "markdown", RawPrompt: "Review."}
+		for _, path := range paths {
+			request.Files = append(request.Files, SelectedFile{Path: path})
+		}
+		incremental, err := app.BuildIncrementalPrompt("work", request, IncrementalFull)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return incremental
+	}
+
+	build(first, second)
+	build(second)
+	snapshot, err := loadWorkspaceSnapshot("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, kept := snapshot[first]; kept || len(snapshot) != 1 {
+		t.Errorf("the snapshot kept files of an older prompt: %v", snapshot)
+	}
+
+	// A file the last prompt left out is sent again in full
+	incremental := build(first, second)
+	if len(incremental.Added) != 1 || incremental.Added[0] != first || len(incremental.Unchanged) != 1 || incremental.Unchanged[0] != second {
+		t.Errorf("added = %v, unchanged = %v", incremental.Added, incremental.Unchanged)
+	}
+	if !strings.Contains(incremental.Result.Prompt, first) {
+		t.Errorf("the file left out of the last prompt is missing:\n%s", incremental.Result.Prompt)
+	}
+}
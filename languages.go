@@ -0,0 +1,125 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// languageSpec describes the lexical syntax needed to find comments and
+// string literals in a source file
+type languageSpec struct {
+	Name          string
+	LineComments  []string
+	BlockComments [][2]string
+	// DocPrefixes are comment openers that always mark documentation
+	DocPrefixes []string
+	// DocBeforeDecl treats plain comments directly above a declaration as documentation
+	DocBeforeDecl bool
+	Quotes        string
+	TripleQuotes  bool
+}
+
+var (
+	cLikeComments = [][2]string{{"/*", "*/"}}
+	cLikeDocs     = []string{"/**", "/*!", "///", "//!"}
+)
+
+var languageSpecs = map[string]*languageSpec{
+	"go":         {Name: "go", LineComments: []string{"//"}, BlockComments: cLikeComments, DocBeforeDecl: true, Quotes: "\"'`"},
+	"javascript": {Name: "javascript", LineComments: []string{"//"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\"'`"},
+	"typescript": {Name: "typescript", LineComments: []string{"//"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\"'`"},
+	"java":       {Name: "java", LineComments: []string{"//"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\"'"},
+	"kotlin":     {Name: "kotlin", LineComments: []string{"//"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\"'", TripleQuotes: true},
+	"scala":      {Name: "scala", LineComments: []string{"//"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\"'", TripleQuotes: true},
+	"c":          {Name: "c", LineComments: []string{"//"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\"'"},
+	"cpp":        {Name: "cpp", LineComments: []string{"//"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\"'"},
+	"csharp":     {Name: "csharp", LineComments: []string{"//"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\"'"},
+	"rust":       {Name: "rust", LineComments: []string{"//"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\""},
+	"swift":      {Name: "swift", LineComments: []string{"//"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\"", TripleQuotes: true},
+	"dart":       {Name: "dart", LineComments: []string{"//"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\"'", TripleQuotes: true},
+	"php":        {Name: "php", LineComments: []string{"//", "#"}, BlockComments: cLikeComments, DocPrefixes: cLikeDocs, Quotes: "\"'"},
+	"css":        {Name: "css", BlockComments: cLikeComments, Quotes: "\"'"},
+	"scss":       {Name: "scss", LineComments: []string{"//"}, BlockComments: cLikeComments, Quotes: "\"'"},
+	"python":     {Name: "python", LineComments: []string{"#"}, Quotes: "\"'", TripleQuotes: true},
+	"ruby":       {Name: "ruby", LineComments: []string{"#"}, DocPrefixes: []string{"##"}, Quotes: "\"'"},
+	"bash":       {Name: "bash", LineComments: []string{"#"}, Quotes: "\"'"},
+	"yaml":       {Name: "yaml", LineComments: []string{"#"}, Quotes: "\"'"},
+	"toml":       {Name: "toml", LineComments: []string{"#"}, Quotes: "\"'", TripleQuotes: true},
+	"perl":       {Name: "perl", LineComments: []string{"#"}, Quotes: "\"'"},
+	"r":          {Name: "r", LineComments: []string{"#"}, DocPrefixes: []string{"#'"}, Quotes: "\"'"},
+	"sql":        {Name: "sql", LineComments: []string{"--"}, BlockComments: cLikeComments, Quotes: "'\""},
+	"lua":        {Name: "lua", LineComments: []string{"--"}, BlockComments: [][2]string{{"--[[", "]]"}}, DocPrefixes: []string{"---"}, Quotes: "\"'"},
+	"haskell":    {Name: "haskell", LineComments: []string{"--"}, BlockComments: [][2]string{{"{-", "-}"}}, DocPrefixes: []string{"-- |", "{-|"}, Quotes: "\""},
+	"html":       {Name: "html", BlockComments: [][2]string{{"<!--", "-->"}}},
+	"xml":        {Name: "xml", BlockComments: [][2]string{{"<!--", "-->"}}},
+	"markdown":   {Name: "markdown"},
+	"json":       {Name: "json", Quotes: "\""},
+	"dockerfile": {Name: "dockerfile", LineComments: []string{"#"}, Quotes: "\"'"},
+	"makefile":   {Name: "makefile", LineComments: []string{"#"}, Quotes: "\"'"},
+}
+
+// languageExtensions maps lower-case file extensions to language names
+var languageExtensions = map[string]string{
+	".go": "go", ".js": "javascript", ".mjs": "javascript", ".cjs": "javascript", ".jsx": "javascript",
+	".ts": "typescript", ".tsx": "typescript", ".mts": "typescript", ".cts": "typescript",
+	".java": "java", ".kt": "kotlin", ".kts": "kotlin", ".scala": "scala",
+	".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp", ".cxx": "cpp", ".hpp": "cpp", ".hh": "cpp",
+	".cs": "csharp", ".rs": "rust", ".swift": "swift", ".dart": "dart", ".php": "php",
+	".css": "css", ".scss": "scss", ".less": "scss",
+	".py": "python", ".pyi": "python", ".rb": "ruby", ".sh": "bash", ".bash": "bash", ".zsh": "bash",
+	".yaml": "yaml", ".yml": "yaml", ".toml": "toml", ".pl": "perl", ".pm": "perl", ".r": "r",
+	".sql": "sql", ".lua": "lua", ".hs": "haskell",
+	".html": "html", ".htm": "html", ".vue": "html", ".svelte": "html", ".xml": "xml", ".svg": "xml",
+	".md": "markdown", ".markdown": "markdown", ".json": "json",
+}
+
+// languageFileNames maps well-known extension-less file names to language names
+var languageFileNames = map[string]string{
+	"dockerfile": "dockerfile", "makefile": "makefile", "gemfile": "ruby", "rakefile": "ruby",
+	".bashrc": "bash", ".zshrc": "bash", ".profile": "bash",
+}
+
+// shebangInterpreters maps script interpreters to language names
+var shebangInterpreters = map[string]string{
+	"sh": "bash", "bash": "bash", "zsh": "bash", "dash": "bash", "ksh": "bash",
+	"python": "python", "python2": "python", "python3": "python",
+	"node": "javascript", "nodejs": "javascript", "deno": "typescript", "ts-node": "typescript", "bun": "typescript",
+	"ruby": "ruby", "perl": "perl", "php": "php", "lua": "lua", "Rscript": "r",
+}
+
+// detectLanguage returns the language of a file from its name, or "" when unknown
+func detectLanguage(path string) string {
+	base := strings.ToLower(filepath.Base(path))
+	if name, ok := languageFileNames[base]; ok {
+		return name
+	}
+	return languageExtensions[strings.ToLower(filepath.Ext(base))]
+}
+
+// detectFileLanguage returns the language of a file from its name, falling
+// back to the interpreter named by a shebang line
+func detectFileLanguage(path string, content string) string {
+	if language := detectLanguage(path); language != "" {
+		return language
+	}
+	if !strings.HasPrefix(content, "#!") {
+		return ""
+	}
+	line, _, _ := strings.Cut(content[2:], "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := filepath.Base(fields[0])
+	// "#!/usr/bin/env -S node --flags" names the interpreter after env and its options
+	if interpreter == "env" {
+		interpreter = ""
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") {
+				interpreter = field
+				break
+			}
+		}
+	}
+	return shebangInterpreters[strings.TrimRight(interpreter, "0123456789.")]
+}
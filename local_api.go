@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	localAPIFileName = "api.json"
+	// localAPISelectionEvent is emitted to the UI whenever the API changes the selection
+	localAPISelectionEvent = "api:selection"
+	localAPIMaxBody        = 32 << 20
+)
+
+// LocalAPIInfo is what clients need to reach the local API. It is written to
+// ~/.code-prompter/api.json while the server runs.
+type LocalAPIInfo struct {
+	URL   string `json:"url"`
+	Port  int    `json:"port"`
+	Token string `json:"token"`
+	PID   int    `json:"pid"`
+}
+
+// APISelection is the selection editors and scripts push through the API
+type APISelection struct {
+	Files        []SelectedFile `json:"files"`
+	Task         string         `json:"task"`
+	Instructions string         `json:"instructions"`
+	Format       string         `json:"format"`
+}
+
+// localAPI is the running server and the selection it manages
+type localAPI struct {
+	mu        sync.Mutex
+	server    *http.Server
+	info      *LocalAPIInfo
+	selection APISelection
+}
+
+// localAPIPath returns the file advertising the running server
+func localAPIPath() (string, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting user home directory: %v", err)
+	}
+	return filepath.Join(dir, localAPIFileName), nil
+}
+
+// StartLocalAPI starts the HTTP API on a random localhost port, or returns
+// the running one. Every request must carry the token as a bearer token.
+func (a *App) StartLocalAPI() (*LocalAPIInfo, error) {
+	a.api.mu.Lock()
+	defer a.api.mu.Unlock()
+	if a.api.info != nil {
+		return a.api.info, nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("error generating API token: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("error starting API server: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	info := &LocalAPIInfo{
+		URL:   fmt.Sprintf("http://127.0.0.1:%d", port),
+		Port:  port,
+		Token: hex.EncodeToString(secret),
+		PID:   os.Getpid(),
+	}
+
+	path, err := localAPIPath()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	content, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error encoding API info: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), privateDirMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error creating settings directory: %v", err)
+	}
+	// The token grants access to local files, so only the user may read it
+	if err := os.WriteFile(path, content, privateFileMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error writing API info file: %v", err)
+	}
+
+	a.api.info = info
+	a.api.server = &http.Server{
+		Handler:           a.localAPIHandler(info),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			runtime.LogError(a.ctx, fmt.Sprintf("Local API server stopped: %v", err))
+		}
+	}(a.api.server)
+	runtime.LogInfo(a.ctx, fmt.Sprintf("Local API listening on %s", info.URL))
+	return info, nil
+}
+
+// StopLocalAPI stops the HTTP API and removes its api.json
+func (a *App) StopLocalAPI() error {
+	a.api.mu.Lock()
+	server := a.api.server
+	if server == nil {
+		a.api.mu.Unlock()
+		return nil
+	}
+	a.api.server, a.api.info = nil, nil
+	if path, err := localAPIPath(); err == nil {
+		os.Remove(path)
+	}
+	a.api.mu.Unlock()
+
+	// Shutdown waits for running requests, and their handlers take the lock
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("error stopping API server: %v", err)
+	}
+	return nil
+}
+
+// LocalAPIStatus returns the running server, or nil when it is stopped
+func (a *App) LocalAPIStatus() *LocalAPIInfo {
+	a.api.mu.Lock()
+	defer a.api.mu.Unlock()
+	return a.api.info
+}
+
+// GetAPISelection returns the selection pushed through the API
+func (a *App) GetAPISelection() APISelection {
+	a.api.mu.Lock()
+	defer a.api.mu.Unlock()
+	return a.api.selectionCopy()
+}
+
+func (s *localAPI) selectionCopy() APISelection {
+	selection := s.selection
+	selection.Files = append([]SelectedFile{}, s.selection.Files...)
+	return selection
+}
+
+// updateSelection changes the selection and tells the UI about it
+func (a *App) updateSelection(change func(selection *APISelection)) APISelection {
+	a.api.mu.Lock()
+	change(&a.api.selection)
+	selection := a.api.selectionCopy()
+	a.api.mu.Unlock()
+
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, localAPISelectionEvent, selection)
+	}
+	return selection
+}
+
+// localAPIHandler routes the API endpoints behind the token check
+func (a *App) localAPIHandler(info *LocalAPIInfo) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/selection", a.handleGetSelection)
+	mux.HandleFunc("POST /v1/files", a.handleAddFiles)
+	mux.HandleFunc("DELETE /v1/files", a.handleRemoveFiles)
+	mux.HandleFunc("POST /v1/task", a.handleSetTask)
+	mux.HandleFunc("GET /v1/formats", a.handleListFormats)
+	mux.HandleFunc("POST /v1/prompt", a.handleBuildPrompt)
+	mux.HandleFunc("POST /v1/tokens", a.handleCountTokens)
+
+	allowedHosts := map[string]bool{
+		"127.0.0.1:" + strconv.Itoa(info.Port): true,
+		"localhost:" + strconv.Itoa(info.Port): true,
+	}
+	expected := []byte("Bearer " + info.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Checking the host defeats DNS rebinding from web pages
+		if !allowedHosts[r.Host] {
+			writeAPIError(w, http.StatusForbidden, fmt.Errorf("invalid host"))
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, fmt.Errorf("invalid or missing token"))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, localAPIMaxBody)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// readAPIBody decodes the JSON request body, writing the error response on failure
+func readAPIBody(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+func (a *App) handleGetSelection(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, http.StatusOK, a.GetAPISelection())
+}
+
+// handleAddFiles adds files to the selection; a file already selected has
+// its ranges and symbols replaced
+func (a *App) handleAddFiles(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Files []SelectedFile `json:"files"`
+	}
+	if !readAPIBody(w, r, &body) {
+		return
+	}
+	for i, file := range body.Files {
+		absolutePath, err := filepath.Abs(file.Path)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("error getting absolute path of %s: %v", file.Path, err))
+			return
+		}
+		if file.Content == "" {
+			if _, err := os.Stat(absolutePath); err != nil {
+				writeAPIError(w, http.StatusBadRequest, fmt.Errorf("error getting file info for %s: %v", file.Path, err))
+				return
+			}
+		}
+		body.Files[i].Path = absolutePath
+	}
+
+	selection := a.updateSelection(func(selection *APISelection) {
+		for _, file := range body.Files {
+			replaced := false
+			for i, selected := range selection.Files {
+				if selected.Path == file.Path {
+					selection.Files[i] = file
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				selection.Files = append(selection.Files, file)
+			}
+		}
+	})
+	writeAPIJSON(w, http.StatusOK, selection)
+}
+
+// handleRemoveFiles removes the listed paths, or every file when none are listed
+func (a *App) handleRemoveFiles(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Paths []string `json:"paths"`
+	}
+	if r.ContentLength != 0 && !readAPIBody(w, r, &body) {
+		return
+	}
+	remove := map[string]bool{}
+	for _, path := range body.Paths {
+		if absolutePath, err := filepath.Abs(path); err == nil {
+			remove[absolutePath] = true
+		}
+	}
+
+	selection := a.updateSelection(func(selection *APISelection) {
+		if len(remove) == 0 {
+			selection.Files = nil
+			return
+		}
+		var kept []SelectedFile
+		for _, file := range selection.Files {
+			if !remove[file.Path] {
+				kept = append(kept, file)
+			}
+		}
+		selection.Files = kept
+	})
+	writeAPIJSON(w, http.StatusOK, selection)
+}
+
+// handleSetTask sets the task, instructions and format. Task types and
+// custom instructions can be given by label or as text.
+func (a *App) handleSetTask(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Task         *string `json:"task"`
+		Instructions *string `json:"instructions"`
+		Format       *string `json:"format"`
+	}
+	if !readAPIBody(w, r, &body) {
+		return
+	}
+	if body.Format != nil && *body.Format != "" {
+		if _, err := resolvePromptFormat(*body.Format); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	selection := a.updateSelection(func(selection *APISelection) {
+		if body.Task != nil {
+			selection.Task = lookupOptionDescription("task_types.json", *body.Task)
+		}
+		if body.Instructions != nil {
+			selection.Instructions = lookupOptionDescription("custom_instructions.json", *body.Instructions)
+		}
+		if body.Format != nil {
+			selection.Format = *body.Format
+		}
+	})
+	writeAPIJSON(w, http.StatusOK, selection)
+}
+
+func (a *App) handleListFormats(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, http.StatusOK, a.PromptFormats())
+}
+
+// apiPromptRequest turns the selection into a prompt request; the body may
+// override the format and add a raw prompt
+func (a *App) apiPromptRequest(w http.ResponseWriter, r *http.Request) (PromptRequest, bool) {
+	var body struct {
+		Format    string `json:"format"`
+		RawPrompt string `json:"rawPrompt"`
+	}
+	if r.ContentLength != 0 && !readAPIBody(w, r, &body) {
+		return PromptRequest{}, false
+	}
+	selection := a.GetAPISelection()
+	request := PromptRequest{
+		Format:       selection.Format,
+		Task:         selection.Task,
+		Instructions: selection.Instructions,
+		RawPrompt:    body.RawPrompt,
+		Files:        selection.Files,
+	}
+	if body.Format != "" {
+		request.Format = body.Format
+	}
+	if request.Format == "" {
+		request.Format = defaultExportFormat
+	}
+	return request, true
+}
+
+// handleBuildPrompt builds the selection like BuildPrompt does, including the
+// history record
+func (a *App) handleBuildPrompt(w http.ResponseWriter, r *http.Request) {
+	request, ok := a.apiPromptRequest(w, r)
+	if !ok {
+		return
+	}
+	result, err := a.BuildPrompt(request)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, result)
+}
+
+// handleCountTokens returns the token estimate of the prompt and of each file
+// without recording anything
+func (a *App) handleCountTokens(w http.ResponseWriter, r *http.Request) {
+	request, ok := a.apiPromptRequest(w, r)
+	if !ok {
+		return
+	}
+	result, err := buildPrompt(request)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{
+		"tokens": result.Tokens,
+		"files":  result.Files,
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"embed"
 	"log"
+	"os"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -18,6 +19,14 @@ var assets embed.FS
 var icon []byte
 
 func main() {
+	// Sub-commands run without opening a window
+	if handled, err := runCLI(os.Args[1:], os.Stdout); handled {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Create an instance of the app structure
 	app := NewApp()
 
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// manifestVersion is the version of the manifest layout
+	manifestVersion = 1
+	// builtinFormatVersion changes whenever a built-in format renders differently
+	builtinFormatVersion = "1"
+)
+
+// ManifestFile records one file of a prompt as it was when the prompt was built
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Commit is the HEAD of the git repository holding the file, if any
+	Commit string `json:"commit,omitempty"`
+}
+
+// PromptManifest describes a prompt precisely enough to rebuild it and to
+// tell what changed since. Directories lists the files next to the selected
+// ones, so files added there later can be reported.
+type PromptManifest struct {
+	Version       int                 `json:"version"`
+	CreatedAt     time.Time           `json:"createdAt"`
+	Format        string              `json:"format"`
+	FormatVersion string              `json:"formatVersion"`
+	Files         []ManifestFile      `json:"files"`
+	Directories   map[string][]string `json:"directories"`
+	Settings      PromptRequest       `json:"settings"`
+}
+
+// ManifestRebuild is a prompt rebuilt from a manifest and the drift found
+type ManifestRebuild struct {
+	Result   *PromptResult `json:"result"`
+	Changed  []string      `json:"changed"`
+	Vanished []string      `json:"vanished"`
+	Added    []string      `json:"added"`
+	// Unavailable lists files whose recorded content could not be found in git;
+	// their current content is used instead when they still exist
+	Unavailable   []string `json:"unavailable"`
+	FormatChanged bool     `json:"formatChanged"`
+}
+
+// hashContent returns the hex SHA-256 of content
+func hashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// formatVersion identifies the exact renderer of a format: built-in formats
+// carry a version number and templates the hash of their content
+func formatVersion(format string) string {
+	if _, ok := promptFormats[strings.ToLower(format)]; ok {
+		return "builtin-" + builtinFormatVersion
+	}
+	path, err := userFormatPath(format)
+	if err != nil {
+		return ""
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return "template-" + hashContent(string(content))[:12]
+}
+
+// gitOutput runs git in dir and returns its output
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+// gitHead is the cached HEAD commit of a repository. stamp records the state
+// of .git/HEAD and the branch it points to when the commit was read.
+type gitHead struct {
+	stamp  string
+	commit string
+}
+
+var (
+	gitHeadsMu sync.Mutex
+	gitHeads   = map[string]gitHead{}
+)
+
+// gitRoot returns the top directory of the repository holding dir, or ""
+// when dir is not inside one
+func gitRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// gitHeadStamp describes HEAD and the ref it names, so a commit, checkout or
+// reset changes it. It is "" when .git is not a plain directory, e.g. in a
+// linked worktree, and the commit is then never cached.
+func gitHeadStamp(root string) string {
+	gitDir := filepath.Join(root, ".git")
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	stamp := strings.TrimSpace(string(head))
+	refPath := filepath.Join(gitDir, "packed-refs")
+	if ref, ok := strings.CutPrefix(stamp, "ref: "); ok {
+		if _, err := os.Stat(filepath.Join(gitDir, ref)); err == nil {
+			refPath = filepath.Join(gitDir, ref)
+		}
+	}
+	if info, err := os.Stat(refPath); err == nil {
+		stamp += fmt.Sprintf("|%d|%d", info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp
+}
+
+// gitHeadCommit returns the HEAD commit of the repository at root, running
+// git only when HEAD moved since the last call
+func gitHeadCommit(root string) string {
+	stamp := gitHeadStamp(root)
+	gitHeadsMu.Lock()
+	cached, ok := gitHeads[root]
+	gitHeadsMu.Unlock()
+	if ok && stamp != "" && cached.stamp == stamp {
+		return cached.commit
+	}
+
+	output, _ := gitOutput(root, "rev-parse", "HEAD")
+	commit := strings.TrimSpace(output)
+	if stamp != "" {
+		gitHeadsMu.Lock()
+		gitHeads[root] = gitHead{stamp: stamp, commit: commit}
+		gitHeadsMu.Unlock()
+	}
+	return commit
+}
+
+// buildManifest hashes the selected files of a request
+func buildManifest(request PromptRequest) (*PromptManifest, error) {
+	manifest := &PromptManifest{
+		Version:       manifestVersion,
+		CreatedAt:     time.Now().UTC(),
+		Format:        request.Format,
+		FormatVersion: formatVersion(request.Format),
+		Directories:   map[string][]string{},
+		Settings:      request,
+	}
+	manifest.Settings.Files = make([]SelectedFile, len(request.Files))
+
+	roots := map[string]string{}
+	for i, file := range request.Files {
+		content, err := readSelectedFile(file)
+		if err != nil {
+			return nil, err
+		}
+		settingsFile := file
+		settingsFile.Content = ""
+		manifest.Settings.Files[i] = settingsFile
+
+		dir := filepath.Dir(file.Path)
+		root, ok := roots[dir]
+		if !ok {
+			root = gitRoot(dir)
+			roots[dir] = root
+		}
+		commit := ""
+		if root != "" {
+			commit = gitHeadCommit(root)
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Path:   file.Path,
+			Size:   int64(len(content)),
+			SHA256: hashContent(content),
+			Commit: commit,
+		})
+
+		if _, listed := manifest.Directories[dir]; !listed {
+			manifest.Directories[dir] = listDirectoryFiles(dir)
+		}
+	}
+	return manifest, nil
+}
+
+// listDirectoryFiles returns the names of the regular files in dir
+func listDirectoryFiles(dir string) []string {
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		return []string{}
+	}
+	names := []string{}
+	for _, item := range items {
+		if item.Type().IsRegular() {
+			names = append(names, item.Name())
+		}
+	}
+	return names
+}
+
+// gitFileContent returns the content of a file at a commit of its repository
+func gitFileContent(path string, commit string) (string, error) {
+	return gitOutput(filepath.Dir(path), "show", commit+":./"+filepath.Base(path))
+}
+
+// RebuildFromManifest builds the prompt of a manifest again and reports the
+// files that changed, vanished or were added next to them since. With
+// useGitContent the files are taken from the commit recorded for them
+// instead of the working tree.
+func (a *App) RebuildFromManifest(manifest PromptManifest, useGitContent bool) (*ManifestRebuild, error) {
+	return rebuildFromManifest(manifest, useGitContent)
+}
+
+func rebuildFromManifest(manifest PromptManifest, useGitContent bool) (*ManifestRebuild, error) {
+	rebuild := &ManifestRebuild{
+		Changed:       []string{},
+		Vanished:      []string{},
+		Added:         []string{},
+		Unavailable:   []string{},
+		FormatChanged: formatVersion(manifest.Format) != manifest.FormatVersion,
+	}
+
+	recorded := map[string]ManifestFile{}
+	for _, file := range manifest.Files {
+		recorded[file.Path] = file
+	}
+
+	request := manifest.Settings
+	request.Files = nil
+	for _, file := range manifest.Settings.Files {
+		record := recorded[file.Path]
+		current, err := os.ReadFile(file.Path)
+		exists := err == nil
+		if !exists {
+			rebuild.Vanished = append(rebuild.Vanished, file.Path)
+		} else if hashContent(string(current)) != record.SHA256 {
+			rebuild.Changed = append(rebuild.Changed, file.Path)
+		}
+
+		if useGitContent {
+			content, err := gitFileContent(file.Path, record.Commit)
+			if record.Commit != "" && err == nil && hashContent(content) == record.SHA256 {
+				file.Content = content
+				request.Files = append(request.Files, file)
+				continue
+			}
+			rebuild.Unavailable = append(rebuild.Unavailable, file.Path)
+		}
+		if exists {
+			request.Files = append(request.Files, file)
+		}
+	}
+
+	for dir, names := range manifest.Directories {
+		known := map[string]bool{}
+		for _, name := range names {
+			known[name] = true
+		}
+		for _, name := range listDirectoryFiles(dir) {
+			if !known[name] {
+				rebuild.Added = append(rebuild.Added, filepath.Join(dir, name))
+			}
+		}
+	}
+	sort.Strings(rebuild.Added)
+
+	result, err := buildPrompt(request)
+	if err != nil {
+		return nil, fmt.Errorf("error rebuilding prompt: %v", err)
+	}
+	rebuild.Result = result
+	return rebuild, nil
+}
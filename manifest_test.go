@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildManifestOutsideGit(t *testing.T) {
+	root := t.TempDir()
+	if gitRoot(root) != "" {
+		t.Skip("the temporary directory is inside a git repository")
+	}
+	path := filepath.Join(root, "main.go")
+	if err := os.WriteFile(path, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "other.go"), []byte("package other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := buildManifest(PromptRequest{Format: "markdown", Files: []SelectedFile{{Path: path}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The SHA-256 of "package main\n", and no commit outside a repository
+	want := ManifestFile{Path: path, Size: 13, SHA256: "df1d036cbbf3df46e2045071e082245ece204c7f53ecf0a4e022bff9bb228f47"}
+	if len(manifest.Files) != 1 || manifest.Files[0] != want {
+		t.Errorf("files = %+v, want %+v", manifest.Files, want)
+	}
+	if got := strings.Join(manifest.Directories[root], ","); got != "main.go,other.go" {
+		t.Errorf("directory listing = %s", got)
+	}
+}
+
+func TestBuildManifestCachesGitHead(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		args = append([]string{"-C", root, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		output, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	sub := filepath.Join(root, "pkg")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(sub, "main.go")
+	if err := os.WriteFile(path, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "first")
+
+	build := func() string {
+		t.Helper()
+		manifest, err := buildManifest(PromptRequest{Format: "markdown", Files: []SelectedFile{{Path: path}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return manifest.Files[0].Commit
+	}
+	if commit := build(); commit != git("rev-parse", "HEAD") {
+		t.Errorf("commit = %q, want HEAD", commit)
+	}
+	if gitRoot(sub) != root {
+		t.Errorf("gitRoot(%s) = %s, want %s", sub, gitRoot(sub), root)
+	}
+	gitHeadsMu.Lock()
+	cached, ok := gitHeads[root]
+	gitHeadsMu.Unlock()
+	if !ok || cached.commit != git("rev-parse", "HEAD") {
+		t.Fatalf("the commit of %s was not cached: %+v", root, cached)
+	}
+
+	// A new commit moves the branch and invalidates the cached commit
+	git("commit", "-q", "--allow-empty", "-m", "second")
+	if commit := build(); commit != git("rev-parse", "HEAD") {
+		t.Errorf("commit after a new commit = %q, want the new HEAD", commit)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// renderMarkdownPrompt renders headings per section and a fenced, language
+// tagged code block per file
+func renderMarkdownPrompt(data *promptData) (string, error) {
+	var b strings.Builder
+
+	if data.Task != "" {
+		fmt.Fprintf(&b, "## Task\n\n%s\n\n", data.Task)
+	}
+	if data.Instructions != "" {
+		fmt.Fprintf(&b, "## Instructions\n\n%s\n\n", data.Instructions)
+	}
+	if data.Note != "" {
+		fmt.Fprintf(&b, "## Note\n\n%s\n\n", data.Note)
+	}
+	if data.Tree != "" {
+		fence := codeFence(data.Tree)
+		fmt.Fprintf(&b, "## Project tree\n\n%stext\n%s\n%s\n\n", fence, data.Tree, fence)
+	}
+	if data.Diff != "" {
+		fence := codeFence(data.Diff)
+		fmt.Fprintf(&b, "## Diff\n\n%sdiff\n%s\n%s\n\n", fence, strings.TrimSuffix(data.Diff, "\n"), fence)
+	}
+
+	if len(data.Files) > 0 {
+		b.WriteString("## Files\n\n")
+		for _, file := range data.Files {
+			heading := "### `" + strings.ReplaceAll(file.Path, "`", "'") + "`"
+			if file.Excerpt() {
+				heading += fmt.Sprintf(" (lines %s of %d)", formatRanges(file.Ranges), file.Lines)
+			}
+			fence := codeFence(file.Content)
+			fmt.Fprintf(&b, "%s\n\n%s%s\n%s\n%s\n\n", heading, fence, file.Language, strings.TrimSuffix(file.Content, "\n"), fence)
+		}
+	}
+
+	b.WriteString(data.RawPrompt)
+	return b.String(), nil
+}
+
+// codeFence returns a backtick fence longer than any backtick run in content,
+// so the content can never close the block early
+func codeFence(content string) string {
+	longest, run := 0, 0
+	for i := 0; i < len(content); i++ {
+		if content[i] == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
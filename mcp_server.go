@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const (
+	mcpProtocolVersion = "2024-11-05"
+	mcpServerName      = "code-prompter"
+
+	instructionResourcePrefix = "code-prompter://instructions/"
+	taskTypeResourcePrefix    = "code-prompter://task-types/"
+)
+
+// JSON-RPC error codes used by the MCP server
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCInternalError  = -32603
+)
+
+// mcpRequest is an incoming JSON-RPC request, or a notification when ID is empty
+type mcpRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type mcpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *mcpError       `json:"error,omitempty"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+// mcpTool is a tool offered to MCP clients. call returns either text, which
+// is passed through, or a value sent as indented JSON.
+type mcpTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+	call        func(arguments json.RawMessage) (interface{}, error)
+}
+
+type mcpResource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType"`
+}
+
+type mcpResourceContent struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// objectSchema builds the JSON schema of a tool taking an object
+func objectSchema(properties map[string]interface{}, required ...string) map[string]interface{} {
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+var selectedFileSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"path":    map[string]interface{}{"type": "string"},
+		"ranges":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"start": map[string]interface{}{"type": "integer"}, "end": map[string]interface{}{"type": "integer"}}}},
+		"symbols": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+	},
+	"required": []string{"path"},
+}
+
+// mcpTools lists the tools in the order they are advertised
+var mcpTools = []mcpTool{
+	{
+		Name:        "list_task_types",
+		Description: "List the curated task types with their descriptions.",
+		InputSchema: objectSchema(map[string]interface{}{}),
+		call: func(json.RawMessage) (interface{}, error) {
+			return loadPromptOptions("task_types.json")
+		},
+	},
+	{
+		Name:        "scan_project",
+		Description: "List the files of a folder that the scanning rules include (ignore folders and suffixes, .gitignore, .codeprompterignore, .code-prompter.yaml) and the skipped paths with the reason.",
+		InputSchema: objectSchema(map[string]interface{}{
+			"path":             map[string]interface{}{"type": "string", "description": "folder to scan"},
+			"recursive":        map[string]interface{}{"type": "boolean", "default": true},
+			"respectGitignore": map[string]interface{}{"type": "boolean", "default": true},
+		}, "path"),
+		call: mcpScanProject,
+	},
+	{
+		Name:        "read_files",
+		Description: "Read the content of files.",
+		InputSchema: objectSchema(map[string]interface{}{
+			"paths": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		}, "paths"),
+		call: mcpReadFiles,
+	},
+	{
+		Name:        "build_prompt",
+		Description: "Build a prompt from files in one of the prompt formats. Task types and instructions can be given by label or as text.",
+		InputSchema: objectSchema(map[string]interface{}{
+			"files":       map[string]interface{}{"type": "array", "items": selectedFileSchema},
+			"format":      map[string]interface{}{"type": "string", "default": defaultExportFormat},
+			"taskType":    map[string]interface{}{"type": "string"},
+			"instruction": map[string]interface{}{"type": "string"},
+			"prompt":      map[string]interface{}{"type": "string", "description": "the task instruction appended after the files"},
+		}, "files"),
+		call: mcpBuildPrompt,
+	},
+	{
+		Name:        "count_tokens",
+		Description: "Estimate the token count of a text or of files.",
+		InputSchema: objectSchema(map[string]interface{}{
+			"text":  map[string]interface{}{"type": "string"},
+			"paths": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		}),
+		call: mcpCountTokens,
+	},
+}
+
+// serveMCP runs the MCP server over newline delimited JSON-RPC until in ends
+func serveMCP(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var request mcpRequest
+		if err := json.Unmarshal([]byte(line), &request); err != nil {
+			response := mcpResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &mcpError{Code: jsonRPCParseError, Message: err.Error()}}
+			if err := encoder.Encode(response); err != nil {
+				return err
+			}
+			continue
+		}
+
+		result, rpcErr := handleMCPRequest(&request)
+		// Notifications get no response
+		if len(request.ID) == 0 {
+			continue
+		}
+		response := mcpResponse{JSONRPC: "2.0", ID: request.ID, Result: result, Error: rpcErr}
+		if err := encoder.Encode(response); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// handleMCPRequest dispatches one request by method
+func handleMCPRequest(request *mcpRequest) (interface{}, *mcpError) {
+	switch request.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities": map[string]interface{}{
+				"tools":     map[string]interface{}{},
+				"resources": map[string]interface{}{},
+			},
+			"serverInfo": map[string]interface{}{"name": mcpServerName, "version": "1.0.0"},
+		}, nil
+	case "ping", "notifications/initialized", "notifications/cancelled":
+		return map[string]interface{}{}, nil
+	case "tools/list":
+		return map[string]interface{}{"tools": mcpTools}, nil
+	case "tools/call":
+		return callMCPTool(request.Params)
+	case "resources/list":
+		resources, err := listMCPResources()
+		if err != nil {
+			return nil, &mcpError{Code: jsonRPCInternalError, Message: err.Error()}
+		}
+		return map[string]interface{}{"resources": resources}, nil
+	case "resources/read":
+		return readMCPResource(request.Params)
+	}
+	return nil, &mcpError{Code: jsonRPCMethodNotFound, Message: "method not found: " + request.Method}
+}
+
+// callMCPTool runs a tool. Failures of the tool itself are reported in the
+// result so the model can see them, as the protocol asks.
+func callMCPTool(params json.RawMessage) (interface{}, *mcpError) {
+	var call struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &call); err != nil {
+		return nil, &mcpError{Code: jsonRPCInvalidParams, Message: err.Error()}
+	}
+	for _, tool := range mcpTools {
+		if tool.Name != call.Name {
+			continue
+		}
+		arguments := call.Arguments
+		if len(arguments) == 0 {
+			arguments = json.RawMessage("{}")
+		}
+		value, err := tool.call(arguments)
+		if err != nil {
+			return mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+		}
+		if text, ok := value.(string); ok {
+			return mcpToolResult{Content: []mcpContent{{Type: "text", Text: text}}}, nil
+		}
+		content, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+		}
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: string(content)}}}, nil
+	}
+	return nil, &mcpError{Code: jsonRPCInvalidParams, Message: "unknown tool: " + call.Name}
+}
+
+func mcpScanProject(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Path             string `json:"path"`
+		Recursive        *bool  `json:"recursive"`
+		RespectGitignore *bool  `json:"respectGitignore"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	if args.Path == "" {
+		return nil, fmt.Errorf("a path is required")
+	}
+	config := map[string]interface{}{}
+	for key, value := range defaultFolderConfig {
+		config[key] = value
+	}
+	if args.Recursive != nil {
+		config["recursive"] = *args.Recursive
+	}
+	if args.RespectGitignore != nil {
+		config["respectGitignore"] = *args.RespectGitignore
+	}
+	return scanFolder(args.Path, config)
+}
+
+func mcpReadFiles(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Paths []string `json:"paths"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, path := range args.Paths {
+		content, err := readSelectedFile(SelectedFile{Path: path})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "File: %s\n%s\n%s\n%s\n\n", path, codeFence(content), strings.TrimSuffix(content, "\n"), codeFence(content))
+	}
+	return strings.TrimSuffix(b.String(), "\n\n"), nil
+}
+
+func mcpBuildPrompt(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Files       []SelectedFile `json:"files"`
+		Format      string         `json:"format"`
+		TaskType    string         `json:"taskType"`
+		Instruction string         `json:"instruction"`
+		Prompt      string         `json:"prompt"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	if args.Format == "" {
+		args.Format = defaultExportFormat
+	}
+	result, err := buildPrompt(PromptRequest{
+		Format:       args.Format,
+		Task:         lookupOptionDescription("task_types.json", args.TaskType),
+		Instructions: lookupOptionDescription("custom_instructions.json", args.Instruction),
+		RawPrompt:    args.Prompt,
+		Files:        args.Files,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Prompt, nil
+}
+
+func mcpCountTokens(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Text  string   `json:"text"`
+		Paths []string `json:"paths"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	type fileTokens struct {
+		Path   string `json:"path"`
+		Tokens int    `json:"tokens"`
+	}
+	result := struct {
+		Tokens int          `json:"tokens"`
+		Files  []fileTokens `json:"files,omitempty"`
+	}{Tokens: estimateTokens(args.Text)}
+	for _, path := range args.Paths {
+		content, err := readSelectedFile(SelectedFile{Path: path})
+		if err != nil {
+			return nil, err
+		}
+		tokens := estimateTokens(content)
+		result.Files = append(result.Files, fileTokens{Path: path, Tokens: tokens})
+		result.Tokens += tokens
+	}
+	return result, nil
+}
+
+// listMCPResources exposes the custom instructions and task types
+func listMCPResources() ([]mcpResource, error) {
+	resources := []mcpResource{}
+	for _, library := range []struct {
+		fileName string
+		prefix   string
+		kind     string
+	}{
+		{"custom_instructions.json", instructionResourcePrefix, "Custom instruction"},
+		{"task_types.json", taskTypeResourcePrefix, "Task type"},
+	} {
+		options, err := loadPromptOptions(library.fileName)
+		if err != nil {
+			return nil, err
+		}
+		for _, option := range options {
+			resources = append(resources, mcpResource{
+				URI:         library.prefix + url.PathEscape(option.Label),
+				Name:        option.Label,
+				Description: library.kind,
+				MimeType:    "text/plain",
+			})
+		}
+	}
+	return resources, nil
+}
+
+func readMCPResource(params json.RawMessage) (interface{}, *mcpError) {
+	var read struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(params, &read); err != nil {
+		return nil, &mcpError{Code: jsonRPCInvalidParams, Message: err.Error()}
+	}
+
+	fileName, label := "", ""
+	switch {
+	case strings.HasPrefix(read.URI, instructionResourcePrefix):
+		fileName, label = "custom_instructions.json", strings.TrimPrefix(read.URI, instructionResourcePrefix)
+	case strings.HasPrefix(read.URI, taskTypeResourcePrefix):
+		fileName, label = "task_types.json", strings.TrimPrefix(read.URI, taskTypeResourcePrefix)
+	default:
+		return nil, &mcpError{Code: jsonRPCInvalidParams, Message: "unknown resource: " + read.URI}
+	}
+	label, err := url.PathUnescape(label)
+	if err != nil {
+		return nil, &mcpError{Code: jsonRPCInvalidParams, Message: "unknown resource: " + read.URI}
+	}
+
+	options, err := loadPromptOptions(fileName)
+	if err != nil {
+		return nil, &mcpError{Code: jsonRPCInternalError, Message: err.Error()}
+	}
+	for _, option := range options {
+		if option.Label == label {
+			return map[string]interface{}{
+				"contents": []mcpResourceContent{{URI: read.URI, MimeType: "text/plain", Text: option.Description}},
+			}, nil
+		}
+	}
+	return nil, &mcpError{Code: jsonRPCInvalidParams, Message: "unknown resource: " + read.URI}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mcpTestClient drives serveMCP over in-memory pipes, like a real client
+// talking to the server over stdio
+type mcpTestClient struct {
+	t       *testing.T
+	in      *io.PipeWriter
+	decoder *json.Decoder
+	done    chan error
+	nextID  int
+}
+
+func newMCPTestClient(t *testing.T) *mcpTestClient {
+	clientToServer, requests := io.Pipe()
+	responses, serverToClient := io.Pipe()
+	client := &mcpTestClient{t: t, in: requests, decoder: json.NewDecoder(responses), done: make(chan error, 1)}
+	go func() {
+		err := serveMCP(clientToServer, serverToClient)
+		serverToClient.Close()
+		client.done <- err
+	}()
+	t.Cleanup(func() {
+		requests.Close()
+		if err := <-client.done; err != nil {
+			t.Errorf("server stopped with an error: %v", err)
+		}
+	})
+	return client
+}
+
+func (c *mcpTestClient) send(message map[string]interface{}) {
+	c.t.Helper()
+	message["jsonrpc"] = "2.0"
+	line, err := json.Marshal(message)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.in.Write(append(line, '\n')); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// call sends a request and decodes the response with the same id
+func (c *mcpTestClient) call(method string, params interface{}) (json.RawMessage, *mcpError) {
+	c.t.Helper()
+	c.nextID++
+	c.send(map[string]interface{}{"id": c.nextID, "method": method, "params": params})
+	var response struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  json.RawMessage `json:"result"`
+		Error   *mcpError       `json:"error"`
+	}
+	if err := c.decoder.Decode(&response); err != nil {
+		c.t.Fatalf("error reading response to %s: %v", method, err)
+	}
+	if response.JSONRPC != "2.0" || string(response.ID) != fmt.Sprint(c.nextID) {
+		c.t.Fatalf("unexpected response envelope to %s: %s %s", method, response.JSONRPC, response.ID)
+	}
+	return response.Result, response.Error
+}
+
+func (c *mcpTestClient) callTool(name string, arguments interface{}) mcpToolResult {
+	c.t.Helper()
+	raw, rpcErr := c.call("tools/call", map[string]interface{}{"name": name, "arguments": arguments})
+	if rpcErr != nil {
+		c.t.Fatalf("tools/call %s failed: %s", name, rpcErr.Message)
+	}
+	var result mcpToolResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		c.t.Fatal(err)
+	}
+	if len(result.Content) != 1 || result.Content[0].Type != "text" {
+		c.t.Fatalf("unexpected content from %s: %+v", name, result.Content)
+	}
+	return result
+}
+
+func TestMCPServerSession(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	mainPath := filepath.Join(root, "main.go")
+	if err := os.WriteFile(mainPath, []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := newMCPTestClient(t)
+
+	raw, rpcErr := client.call("initialize", map[string]interface{}{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]interface{}{"name": "test", "version": "1.0.0"},
+	})
+	if rpcErr != nil {
+		t.Fatalf("initialize failed: %s", rpcErr.Message)
+	}
+	var initialized struct {
+		ProtocolVersion string                     `json:"protocolVersion"`
+		Capabilities    map[string]json.RawMessage `json:"capabilities"`
+		ServerInfo      struct {
+			Name string `json:"name"`
+		} `json:"serverInfo"`
+	}
+	if err := json.Unmarshal(raw, &initialized); err != nil {
+		t.Fatal(err)
+	}
+	if initialized.ProtocolVersion != mcpProtocolVersion || initialized.ServerInfo.Name != mcpServerName {
+		t.Errorf("unexpected initialize result: %s", raw)
+	}
+	if _, ok := initialized.Capabilities["tools"]; !ok {
+		t.Errorf("tools capability is missing: %s", raw)
+	}
+	// The notification gets no response, so the next response read must
+	// belong to tools/list
+	client.send(map[string]interface{}{"method": "notifications/initialized"})
+
+	raw, rpcErr = client.call("tools/list", nil)
+	if rpcErr != nil {
+		t.Fatalf("tools/list failed: %s", rpcErr.Message)
+	}
+	var listed struct {
+		Tools []struct {
+			Name        string                 `json:"name"`
+			InputSchema map[string]interface{} `json:"inputSchema"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(raw, &listed); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tool := range listed.Tools {
+		names = append(names, tool.Name)
+		if tool.InputSchema["type"] != "object" {
+			t.Errorf("tool %s has no object schema", tool.Name)
+		}
+	}
+	want := "list_task_types,scan_project,read_files,build_prompt,count_tokens"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("tools = %s, want %s", got, want)
+	}
+
+	counted := client.callTool("count_tokens", map[string]interface{}{"text": "hello world", "paths": []string{mainPath}})
+	if counted.IsError {
+		t.Fatalf("count_tokens failed: %s", counted.Content[0].Text)
+	}
+	var tokens struct {
+		Tokens int `json:"tokens"`
+		Files  []struct {
+			Path   string `json:"path"`
+			Tokens int    `json:"tokens"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal([]byte(counted.Content[0].Text), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens.Files) != 1 || tokens.Files[0].Path != mainPath || tokens.Files[0].Tokens <= 0 || tokens.Tokens <= tokens.Files[0].Tokens {
+		t.Errorf("unexpected token counts: %s", counted.Content[0].Text)
+	}
+
+	read := client.callTool("read_files", map[string]interface{}{"paths": []string{mainPath}})
+	if read.IsError || !strings.Contains(read.Content[0].Text, "File: "+mainPath) || !strings.Contains(read.Content[0].Text, "func main() {}") {
+		t.Errorf("unexpected read_files result: %+v", read)
+	}
+
+	scanned := client.callTool("scan_project", map[string]interface{}{"path": root})
+	if scanned.IsError || !strings.Contains(scanned.Content[0].Text, "main.go") {
+		t.Errorf("unexpected scan_project result: %+v", scanned)
+	}
+
+	// A failing tool reports the error in its result
+	missing := client.callTool("read_files", map[string]interface{}{"paths": []string{filepath.Join(root, "missing.go")}})
+	if !missing.IsError {
+		t.Errorf("reading a missing file did not fail: %+v", missing)
+	}
+
+	if _, rpcErr := client.call("tools/call", map[string]interface{}{"name": "no_such_tool"}); rpcErr == nil || rpcErr.Code != jsonRPCInvalidParams {
+		t.Errorf("unknown tool error = %+v, want invalid params", rpcErr)
+	}
+	if _, rpcErr := client.call("no/such/method", nil); rpcErr == nil || rpcErr.Code != jsonRPCMethodNotFound {
+		t.Errorf("unknown method error = %+v, want method not found", rpcErr)
+	}
+}
+
+func TestMCPListTaskTypesFallsBackToDefaults(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	client := newMCPTestClient(t)
+	listed := client.callTool("list_task_types", map[string]interface{}{})
+	if listed.IsError {
+		t.Fatalf("list_task_types failed: %s", listed.Content[0].Text)
+	}
+	var options []promptOption
+	if err := json.Unmarshal([]byte(listed.Content[0].Text), &options); err != nil {
+		t.Fatal(err)
+	}
+	var labels []string
+	for _, option := range options {
+		labels = append(labels, option.Label+": "+option.Description)
+	}
+	want := "Implement Feature: Implement a new feature.|Fix Bug: Fix a bug or issue.|Refactor Code: Refactor existing code."
+	if got := strings.Join(labels, "|"); got != want {
+		t.Errorf("task types = %s, want %s", got, want)
+	}
+}
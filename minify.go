@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultMaxLiteralLength = 120
+	// minMaxLiteralLength leaves room for the quotes and a few characters
+	minMaxLiteralLength   = 16
+	defaultMaxFixtureRows = 3
+	// numericRunKeep is how many values of a long numeric list are kept
+	numericRunKeep = 8
+)
+
+// MinifyOptions selects the transformations applied to file contents at render time
+type MinifyOptions struct {
+	StripComments        bool `json:"stripComments"`
+	KeepDocComments      bool `json:"keepDocComments"`
+	CollapseBlankLines   bool `json:"collapseBlankLines"`
+	RemoveLicenseHeaders bool `json:"removeLicenseHeaders"`
+	ShortenLiterals      bool `json:"shortenLiterals"`
+	MaxLiteralLength     int  `json:"maxLiteralLength,omitempty"`
+	ShortenFixtureTables bool `json:"shortenFixtureTables"`
+	MaxFixtureRows       int  `json:"maxFixtureRows,omitempty"`
+}
+
+// FileTokenReport is the estimated token count of a file before and after minification
+type FileTokenReport struct {
+	Path   string `json:"path"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+}
+
+type sourceSpanKind int
+
+const (
+	spanComment sourceSpanKind = iota
+	spanString
+)
+
+// sourceSpan is a comment or string literal found by scanSource, as byte offsets
+type sourceSpan struct {
+	kind       sourceSpanKind
+	start, end int
+	doc        bool
+}
+
+var (
+	licensePattern    = regexp.MustCompile(`(?i)copyright|licen[cs]e|spdx-license-identifier`)
+	numericRunPattern = regexp.MustCompile(`(?:(?:0[xX][0-9a-fA-F]+|-?\d+(?:\.\d+)?)\s*,\s*){16,}(?:0[xX][0-9a-fA-F]+|-?\d+(?:\.\d+)?)?`)
+	numericPattern    = regexp.MustCompile(`0[xX][0-9a-fA-F]+|-?\d+(?:\.\d+)?`)
+	declPattern       = regexp.MustCompile(`^(?:func|type|var|const|package)\b|^[A-Z]\w*`)
+	packagePattern    = regexp.MustCompile(`^(?:package|namespace)\b`)
+	testFilePattern   = regexp.MustCompile(`(?i)(_test\.go|[._-](test|spec)\.[a-z]+|(^|/)test_[^/]+\.py)$`)
+	fixtureRowPattern = regexp.MustCompile(`^\s*[{(\[].*[})\]],\s*(?://.*|#.*)?$`)
+)
+
+// minifyContent applies the enabled transformations to the content of path
+func minifyContent(path string, content string, options MinifyOptions) string {
+	spec := languageSpecs[detectLanguage(path)]
+
+	if spec != nil && options.RemoveLicenseHeaders {
+		content = removeLicenseHeader(content, spec)
+	}
+	if options.ShortenLiterals {
+		content = shortenLiterals(content, spec, options.MaxLiteralLength)
+	}
+	if spec != nil && options.StripComments {
+		content = stripComments(content, spec, options.KeepDocComments)
+	}
+	if options.ShortenFixtureTables && testFilePattern.MatchString(path) {
+		content = shortenFixtureTables(content, options.MaxFixtureRows, spec)
+	}
+	if options.CollapseBlankLines {
+		content = collapseBlankLines(content)
+	}
+	return content
+}
+
+// scanSource finds the comments and string literals of content
+func scanSource(content string, spec *languageSpec) []sourceSpan {
+	var spans []sourceSpan
+
+	for i := 0; i < len(content); {
+		rest := content[i:]
+
+		if span, ok := scanComment(content, i, spec); ok {
+			spans = append(spans, span)
+			i = span.end
+			continue
+		}
+
+		if spec.TripleQuotes && (strings.HasPrefix(rest, `"""`) || strings.HasPrefix(rest, `'''`)) {
+			end := strings.Index(rest[3:], rest[:3])
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 6
+			}
+			spans = append(spans, sourceSpan{kind: spanString, start: i, end: i + end})
+			i += end
+			continue
+		}
+
+		if quote := rest[0]; strings.IndexByte(spec.Quotes, quote) >= 0 {
+			end := 1
+			for end < len(rest) {
+				c := rest[end]
+				if c == '\\' && !(quote == '`' && spec.Name == "go") {
+					end += 2
+					continue
+				}
+				end++
+				if c == quote || (c == '\n' && quote != '`') {
+					break
+				}
+			}
+			end = min(end, len(rest))
+			spans = append(spans, sourceSpan{kind: spanString, start: i, end: i + end})
+			i += end
+			continue
+		}
+
+		i++
+	}
+
+	if spec.DocBeforeDecl {
+		markDeclarationDocs(content, spans)
+	}
+	return spans
+}
+
+// scanComment reports the comment starting at offset i, if any
+func scanComment(content string, i int, spec *languageSpec) (sourceSpan, bool) {
+	rest := content[i:]
+
+	for _, block := range spec.BlockComments {
+		if strings.HasPrefix(rest, block[0]) {
+			end := strings.Index(rest[len(block[0]):], block[1])
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += len(block[0]) + len(block[1])
+			}
+			return sourceSpan{kind: spanComment, start: i, end: i + end, doc: hasDocPrefix(rest, spec)}, true
+		}
+	}
+
+	for _, opener := range spec.LineComments {
+		if !strings.HasPrefix(rest, opener) {
+			continue
+		}
+		// A hash only starts a comment at the beginning of a word, not in $# or a#b
+		if opener == "#" && i > 0 && !strings.ContainsRune(" \t\n", rune(content[i-1])) {
+			continue
+		}
+		end := strings.IndexByte(rest, '\n')
+		if end < 0 {
+			end = len(rest)
+		}
+		return sourceSpan{kind: spanComment, start: i, end: i + end, doc: hasDocPrefix(rest, spec)}, true
+	}
+	return sourceSpan{}, false
+}
+
+func hasDocPrefix(rest string, spec *languageSpec) bool {
+	for _, prefix := range spec.DocPrefixes {
+		// "/**/" is an empty block comment, not a doc comment
+		if strings.HasPrefix(rest, prefix) && !strings.HasPrefix(rest, "/**/") {
+			return true
+		}
+	}
+	return false
+}
+
+// markDeclarationDocs marks comment blocks that sit directly above a
+// declaration as documentation, the way Go doc comments work
+func markDeclarationDocs(content string, spans []sourceSpan) {
+	for i := len(spans) - 1; i >= 0; i-- {
+		span := spans[i]
+		if span.kind != spanComment || !ownsLine(content, span.start) {
+			continue
+		}
+		next := span.end
+		if next < len(content) && content[next] == '\n' {
+			next++
+		}
+		lineEnd := strings.IndexByte(content[next:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(content) - next
+		}
+		line := strings.TrimSpace(content[next : next+lineEnd])
+
+		switch {
+		case line == "":
+		case i+1 < len(spans) && spans[i+1].kind == spanComment && spans[i+1].start == next+strings.Index(content[next:], line):
+			spans[i].doc = spans[i+1].doc
+		case declPattern.MatchString(line):
+			spans[i].doc = true
+		}
+	}
+}
+
+// ownsLine reports whether only whitespace precedes offset on its line
+func ownsLine(content string, offset int) bool {
+	lineStart := strings.LastIndexByte(content[:offset], '\n') + 1
+	return strings.TrimSpace(content[lineStart:offset]) == ""
+}
+
+// removeLicenseHeader drops a leading comment block that mentions a license
+// or copyright, keeping a shebang line if there is one. The block must be
+// followed by a blank line or the package clause; a comment directly above
+// any other code documents it and is kept.
+func removeLicenseHeader(content string, spec *languageSpec) string {
+	offset := 0
+	if strings.HasPrefix(content, "#!") {
+		offset = strings.IndexByte(content, '\n') + 1
+		if offset == 0 {
+			return content
+		}
+	}
+	start := offset + len(content[offset:]) - len(strings.TrimLeft(content[offset:], " \t\r\n"))
+
+	// Take the first comment and any comments that directly follow it
+	blockEnd, next := start, start
+	for {
+		span, ok := scanComment(content, next, spec)
+		if !ok {
+			break
+		}
+		blockEnd = span.end
+		next = blockEnd + len(content[blockEnd:]) - len(strings.TrimLeft(content[blockEnd:], " \t\r\n"))
+		if strings.Count(content[blockEnd:next], "\n") > 1 {
+			break
+		}
+	}
+	if blockEnd == start || !licensePattern.MatchString(content[start:blockEnd]) {
+		return content
+	}
+	detached := next == len(content) || strings.Count(content[blockEnd:next], "\n") > 1
+	if !detached && !packagePattern.MatchString(content[next:]) {
+		return content
+	}
+	return content[:offset] + content[next:]
+}
+
+// shortenLiterals truncates string literals and numeric lists that are longer
+// than maxLength, noting how much was left out
+func shortenLiterals(content string, spec *languageSpec, maxLength int) string {
+	if maxLength <= 0 {
+		maxLength = defaultMaxLiteralLength
+	}
+	maxLength = max(maxLength, minMaxLiteralLength)
+
+	if spec != nil {
+		spans := scanSource(content, spec)
+		var b strings.Builder
+		last := 0
+		for _, span := range spans {
+			if span.kind != spanString || span.end-span.start <= maxLength {
+				continue
+			}
+			literal := content[span.start:span.end]
+			quoteLen := 1
+			if spec.TripleQuotes && (strings.HasPrefix(literal, `"""`) || strings.HasPrefix(literal, `'''`)) {
+				quoteLen = 3
+			}
+			keep := max(maxLength-quoteLen, quoteLen)
+			// Avoid cutting a multi-byte character in half
+			for keep > quoteLen && !isRuneStart(literal[keep]) {
+				keep--
+			}
+			b.WriteString(content[last:span.start])
+			fmt.Fprintf(&b, "%s...(+%d chars)%s", literal[:keep], len(literal)-keep-quoteLen, literal[len(literal)-quoteLen:])
+			last = span.end
+		}
+		b.WriteString(content[last:])
+		content = b.String()
+	}
+
+	return numericRunPattern.ReplaceAllStringFunc(content, func(run string) string {
+		values := numericPattern.FindAllStringIndex(run, -1)
+		if len(values) <= numericRunKeep {
+			return run
+		}
+		cut := values[numericRunKeep-1][1]
+		return fmt.Sprintf("%s, ...(+%d values)", run[:cut], len(values)-numericRunKeep)
+	})
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// stripComments removes comments, dropping lines that held nothing else
+func stripComments(content string, spec *languageSpec, keepDocs bool) string {
+	spans := scanSource(content, spec)
+
+	var stripped strings.Builder
+	last := 0
+	for _, span := range spans {
+		if span.kind != spanComment || (keepDocs && span.doc) {
+			continue
+		}
+		if span.start == 0 && strings.HasPrefix(content, "#!") {
+			continue
+		}
+		stripped.WriteString(content[last:span.start])
+		// Keep the line breaks of block comments so line structure survives
+		stripped.WriteString(strings.Repeat("\n", strings.Count(content[span.start:span.end], "\n")))
+		last = span.end
+	}
+	stripped.WriteString(content[last:])
+
+	original := strings.Split(content, "\n")
+	var lines []string
+	for i, line := range strings.Split(stripped.String(), "\n") {
+		if strings.TrimSpace(line) == "" && strings.TrimSpace(original[i]) != "" {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \t"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// shortenFixtureTables keeps the first rows of long runs of one-line table
+// entries, as found in table driven tests
+func shortenFixtureTables(content string, maxRows int, spec *languageSpec) string {
+	if maxRows <= 0 {
+		maxRows = defaultMaxFixtureRows
+	}
+	marker := "..."
+	if spec != nil && len(spec.LineComments) > 0 {
+		marker = spec.LineComments[0] + " ..."
+	}
+
+	lines := strings.Split(content, "\n")
+	var result []string
+	for i := 0; i < len(lines); {
+		j := i
+		indent := leadingWhitespace(lines[i])
+		for j < len(lines) && fixtureRowPattern.MatchString(lines[j]) && leadingWhitespace(lines[j]) == indent {
+			j++
+		}
+		if j-i > maxRows+1 {
+			result = append(result, lines[i:i+maxRows]...)
+			result = append(result, fmt.Sprintf("%s%s %d more rows", lines[i][:indent], marker, j-i-maxRows))
+			i = j
+			continue
+		}
+		if j == i {
+			j++
+		}
+		result = append(result, lines[i:j]...)
+		i = j
+	}
+	return strings.Join(result, "\n")
+}
+
+// collapseBlankLines trims trailing whitespace and squeezes runs of blank lines
+// into one. Content with nothing but whitespace becomes empty.
+func collapseBlankLines(content string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			if blank || len(lines) == 0 {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n"
+}
@@ -0,0 +1,107 @@
+package main
+
+import "testing"
+
+func TestRemoveLicenseHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		want    string
+	}{
+		{
+			name:    "doc comment of a declaration",
+			path:    "key.go",
+			content: "// LicenseKey returns the key\nfunc LicenseKey() string { return \"\" }\n",
+			want:    "// LicenseKey returns the key\nfunc LicenseKey() string { return \"\" }\n",
+		},
+		{
+			name:    "header before the package clause",
+			path:    "main.go",
+			content: "// Copyright 2024 Example\n// Use of this source code is governed by the MIT license.\npackage main\n",
+			want:    "package main\n",
+		},
+		{
+			name:    "header followed by a blank line",
+			path:    "app.js",
+			content: "/* SPDX-License-Identifier: MIT */\n\n// License checks\nfunction check() {}\n",
+			want:    "// License checks\nfunction check() {}\n",
+		},
+		{
+			name:    "shebang kept",
+			path:    "run.py",
+			content: "#!/usr/bin/env python3\n# Copyright 2024 Example\n\nprint(1)\n",
+			want:    "#!/usr/bin/env python3\nprint(1)\n",
+		},
+		{
+			name:    "comment without a license",
+			path:    "main.go",
+			content: "// Package main runs the app\n\npackage main\n",
+			want:    "// Package main runs the app\n\npackage main\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := minifyContent(test.path, test.content, MinifyOptions{RemoveLicenseHeaders: true})
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestShortenLiterals(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		content   string
+		maxLength int
+		want      string
+	}{
+		{
+			name:      "limit below the minimum",
+			path:      "x.py",
+			content:   "x='" + "abcdefghijklmnopqrstuvwxyz" + "'",
+			maxLength: 1,
+			want:      "x='abcdefghijklmn...(+12 chars)'",
+		},
+		{
+			name:      "short literal untouched",
+			path:      "x.py",
+			content:   "x='ab'",
+			maxLength: 1,
+			want:      "x='ab'",
+		},
+		{
+			name:      "triple quotes",
+			path:      "x.py",
+			content:   `x="""` + "abcdefghijklmnopqrstuvwxyz" + `"""`,
+			maxLength: 16,
+			want:      `x="""abcdefghij...(+16 chars)"""`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := minifyContent(test.path, test.content, MinifyOptions{ShortenLiterals: true, MaxLiteralLength: test.maxLength})
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCollapseBlankLines(t *testing.T) {
+	for _, test := range []struct {
+		content string
+		want    string
+	}{
+		{"", ""},
+		{"  \n\t\n\r\n", ""},
+		{"\n\na  \n\n\n\nb\n\n", "a\n\nb\n"},
+		{"a", "a\n"},
+	} {
+		if got := collapseBlankLines(test.content); got != test.want {
+			t.Errorf("collapseBlankLines(%q) = %q, want %q", test.content, got, test.want)
+		}
+	}
+}
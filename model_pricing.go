@@ -0,0 +1,67 @@
+package main
+
+import "regexp"
+
+// modelPrice is the list price of a model in US dollars per million tokens
+type modelPrice struct {
+	Input      float64
+	Output     float64
+	CacheRead  float64
+	CacheWrite float64
+}
+
+// modelPrices maps model families to their list prices. Dated versions and
+// -latest aliases match their family, e.g. claude-sonnet-4-20250514; variants
+// such as o3-mini are other models and need their own entry.
+var modelPrices = map[string]modelPrice{
+	"gpt-4o":            {Input: 2.5, Output: 10, CacheRead: 1.25},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.6, CacheRead: 0.075},
+	"gpt-4.1":           {Input: 2, Output: 8, CacheRead: 0.5},
+	"gpt-4.1-mini":      {Input: 0.4, Output: 1.6, CacheRead: 0.1},
+	"gpt-4.1-nano":      {Input: 0.1, Output: 0.4, CacheRead: 0.025},
+	"o3":                {Input: 2, Output: 8, CacheRead: 0.5},
+	"o3-mini":           {Input: 1.1, Output: 4.4, CacheRead: 0.55},
+	"o3-pro":            {Input: 20, Output: 80},
+	"o4-mini":           {Input: 1.1, Output: 4.4, CacheRead: 0.275},
+	"claude-opus-4":     {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"claude-sonnet-4":   {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-3-7-sonnet": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-3-5-sonnet": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-3-5-haiku":  {Input: 0.8, Output: 4, CacheRead: 0.08, CacheWrite: 1},
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25, CacheRead: 0.03, CacheWrite: 0.3},
+}
+
+// modelVersionSuffix matches the version of a model name: a date as OpenAI
+// (-2024-08-06) or Anthropic (-20250514) write it, or -latest
+var modelVersionSuffix = regexp.MustCompile(`-(\d{4}-\d{2}-\d{2}|\d{8}|latest)$`)
+
+// lookupModelPrice finds the price of a model's family
+func lookupModelPrice(model string) (modelPrice, bool) {
+	price, ok := modelPrices[modelVersionSuffix.ReplaceAllString(model, "")]
+	return price, ok
+}
+
+// estimateCost returns the cost of a response in US dollars, or nil when
+// the price of the model is unknown. Local models and cached answers are free.
+func estimateCost(response *ChatResponse) *float64 {
+	cost := 0.0
+	if response.Cached || response.Provider == "ollama" {
+		return &cost
+	}
+	price, ok := lookupModelPrice(response.Model)
+	if !ok {
+		return nil
+	}
+
+	usage := response.Usage
+	input := usage.InputTokens
+	// OpenAI counts cached tokens as part of the input, Anthropic separately
+	if response.Provider == "openai" {
+		input -= usage.CacheReadTokens
+	}
+	cost = (float64(input)*price.Input +
+		float64(usage.OutputTokens)*price.Output +
+		float64(usage.CacheReadTokens)*price.CacheRead +
+		float64(usage.CacheWriteTokens)*price.CacheWrite) / 1e6
+	return &cost
+}
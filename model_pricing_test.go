@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLookupModelPrice(t *testing.T) {
+	for _, test := range []struct {
+		model string
+		input float64
+		ok    bool
+	}{
+		{"gpt-4o", 2.5, true},
+		{"gpt-4o-2024-08-06", 2.5, true},
+		{"gpt-4o-mini-2024-07-18", 0.15, true},
+		{"o3", 2, true},
+		{"o3-2025-04-16", 2, true},
+		{"o3-mini", 1.1, true},
+		{"o3-pro-2025-06-10", 20, true},
+		{"claude-sonnet-4-20250514", 3, true},
+		{"claude-3-5-haiku-latest", 0.8, true},
+		{"o3-deep-research", 0, false},
+		{"claude-sonnet-4-5-20250929", 0, false},
+		{"llama3:8b", 0, false},
+	} {
+		price, ok := lookupModelPrice(test.model)
+		if ok != test.ok || price.Input != test.input {
+			t.Errorf("lookupModelPrice(%s) = %v, %v, want input %v, %v", test.model, price, ok, test.input, test.ok)
+		}
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		response ChatResponse
+		want     float64
+	}{
+		{
+			// OpenAI counts the cached tokens in the input: 600 full price, 400 cached
+			name:     "openai cached input",
+			response: ChatResponse{Provider: "openai", Model: "gpt-4.1-2025-04-14", Usage: ChatUsage{InputTokens: 1000, OutputTokens: 100, CacheReadTokens: 400}},
+			want:     (600*2 + 100*8 + 400*0.5) / 1e6,
+		},
+		{
+			// Anthropic reports cache reads and writes apart from the input
+			name:     "anthropic cache writes",
+			response: ChatResponse{Provider: "anthropic", Model: "claude-sonnet-4-20250514", Usage: ChatUsage{InputTokens: 12, OutputTokens: 15, CacheWriteTokens: 2048, CacheReadTokens: 1024}},
+			want:     (12*3 + 15*15 + 1024*0.3 + 2048*3.75) / 1e6,
+		},
+		{name: "ollama", response: ChatResponse{Provider: "ollama", Model: "llama3:8b", Usage: ChatUsage{InputTokens: 1000}}},
+		{name: "cached answer", response: ChatResponse{Provider: "openai", Model: "gpt-4o", Cached: true, Usage: ChatUsage{InputTokens: 1000}}},
+	} {
+		cost := estimateCost(&test.response)
+		if cost == nil {
+			t.Errorf("%s: no cost", test.name)
+			continue
+		}
+		if math.Abs(*cost-test.want) > 1e-12 {
+			t.Errorf("%s: cost = %v, want %v", test.name, *cost, test.want)
+		}
+	}
+
+	if cost := estimateCost(&ChatResponse{Provider: "openai", Model: "unknown-model", Usage: ChatUsage{InputTokens: 1000}}); cost != nil {
+		t.Errorf("unknown model cost = %v, want nil", *cost)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOllamaBaseURL = "http://localhost:11434"
+	// ollamaContextStep rounds the context window requested from Ollama
+	ollamaContextStep = 1024
+	// ollamaContextHeadroom is the share added to the estimated prompt size,
+	// since the estimate is not made with the model's tokenizer and the chat
+	// template adds tokens of its own
+	ollamaContextHeadroom = 0.25
+	// ollamaReplyTokens is reserved for the answer when no max tokens are set
+	ollamaReplyTokens = 2048
+)
+
+// ollamaProvider talks to a local Ollama server so prompts never leave the
+// machine. Ollama truncates prompts longer than its context window without
+// an error, so every chat asks for a window large enough for the prompt.
+type ollamaProvider struct{}
+
+func (ollamaProvider) defaultBaseURL() string { return defaultOllamaBaseURL }
+
+// OllamaModel is a model installed in Ollama
+type OllamaModel struct {
+	Name          string    `json:"name"`
+	Size          int64     `json:"size"`
+	Family        string    `json:"family"`
+	ParameterSize string    `json:"parameterSize"`
+	Quantization  string    `json:"quantization"`
+	ModifiedAt    time.Time `json:"modifiedAt"`
+	// ContextLength is the model's context window in tokens, 0 when unknown
+	ContextLength int `json:"contextLength"`
+}
+
+// ContextCheck compares the size of a prompt with a model's context window
+type ContextCheck struct {
+	Model         string `json:"model"`
+	ContextLength int    `json:"contextLength"`
+	PromptTokens  int    `json:"promptTokens"`
+	ReplyTokens   int    `json:"replyTokens"`
+	Exceeded      bool   `json:"exceeded"`
+	Warning       string `json:"warning,omitempty"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  ollamaOptions   `json:"options,omitempty"`
+}
+
+type ollamaOptions struct {
+	NumPredict  int      `json:"num_predict,omitempty"`
+	NumCtx      int      `json:"num_ctx,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+}
+
+type ollamaChatChunk struct {
+	Model   string `json:"model"`
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+type ollamaTagsResponse struct {
+	Models []struct {
+		Name       string    `json:"name"`
+		Size       int64     `json:"size"`
+		ModifiedAt time.Time `json:"modified_at"`
+		Details    struct {
+			Family            string `json:"family"`
+			ParameterSize     string `json:"parameter_size"`
+			QuantizationLevel string `json:"quantization_level"`
+		} `json:"details"`
+	} `json:"models"`
+}
+
+type ollamaShowResponse struct {
+	ModelInfo map[string]interface{} `json:"model_info"`
+}
+
+// ollamaPost sends a JSON body to an Ollama endpoint
+func ollamaPost(ctx context.Context, baseURL string, path string, body interface{}) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request body: %v", err)
+	}
+	url := providerURL(ChatRequest{BaseURL: baseURL}, defaultOllamaBaseURL, path)
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	return sendProviderRequest(httpRequest, "ollama", 0)
+}
+
+// ollamaContextEntry is a cached context window. modifiedAt is the model's
+// modification time when listed, zero when it was looked up for a chat.
+type ollamaContextEntry struct {
+	length     int
+	modifiedAt time.Time
+}
+
+// ollamaContextLengths caches the context window per server and model; it
+// only changes when the model is pulled again
+var (
+	ollamaContextMu      sync.Mutex
+	ollamaContextLengths = map[string]ollamaContextEntry{}
+)
+
+func ollamaContextKey(baseURL string, model string) string {
+	return providerURL(ChatRequest{BaseURL: baseURL}, defaultOllamaBaseURL, "") + "|" + model
+}
+
+// ollamaContextLength returns the context window of a model, asking the
+// server only the first time
+func ollamaContextLength(ctx context.Context, baseURL string, model string) (int, error) {
+	key := ollamaContextKey(baseURL, model)
+	ollamaContextMu.Lock()
+	entry, ok := ollamaContextLengths[key]
+	ollamaContextMu.Unlock()
+	if ok {
+		return entry.length, nil
+	}
+	return refreshOllamaContextLength(ctx, baseURL, model, time.Time{})
+}
+
+// refreshOllamaContextLength reads the context window of a model from
+// /api/show and caches it
+func refreshOllamaContextLength(ctx context.Context, baseURL string, model string, modifiedAt time.Time) (int, error) {
+	length, err := fetchOllamaContextLength(ctx, baseURL, model)
+	if err != nil {
+		return 0, err
+	}
+	ollamaContextMu.Lock()
+	ollamaContextLengths[ollamaContextKey(baseURL, model)] = ollamaContextEntry{length: length, modifiedAt: modifiedAt}
+	ollamaContextMu.Unlock()
+	return length, nil
+}
+
+// fetchOllamaContextLength reads the context window of a model from /api/show
+func fetchOllamaContextLength(ctx context.Context, baseURL string, model string) (int, error) {
+	httpResponse, err := ollamaPost(ctx, baseURL, "/api/show", map[string]string{"model": model})
+	if err != nil {
+		return 0, err
+	}
+	defer httpResponse.Body.Close()
+
+	var show ollamaShowResponse
+	if err := json.NewDecoder(httpResponse.Body).Decode(&show); err != nil {
+		return 0, fmt.Errorf("error parsing model details: %v", err)
+	}
+	// The key is prefixed with the architecture, e.g. llama.context_length
+	if architecture, ok := show.ModelInfo["general.architecture"].(string); ok {
+		if length, ok := show.ModelInfo[architecture+".context_length"].(float64); ok {
+			return int(length), nil
+		}
+	}
+	for key, value := range show.ModelInfo {
+		if length, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			return int(length), nil
+		}
+	}
+	return 0, nil
+}
+
+// listOllamaModels returns the installed models with their context windows
+func listOllamaModels(ctx context.Context, baseURL string) ([]OllamaModel, error) {
+	url := providerURL(ChatRequest{BaseURL: baseURL}, defaultOllamaBaseURL, "/api/tags")
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	httpResponse, err := sendProviderRequest(httpRequest, "ollama", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	var tags ollamaTagsResponse
+	if err := json.NewDecoder(httpResponse.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("error parsing model list: %v", err)
+	}
+	models := make([]OllamaModel, 0, len(tags.Models))
+	for _, tag := range tags.Models {
+		model := OllamaModel{
+			Name:          tag.Name,
+			Size:          tag.Size,
+			Family:        tag.Details.Family,
+			ParameterSize: tag.Details.ParameterSize,
+			Quantization:  tag.Details.QuantizationLevel,
+			ModifiedAt:    tag.ModifiedAt,
+		}
+		// Only models pulled again since the last lookup are asked for again
+		ollamaContextMu.Lock()
+		entry, ok := ollamaContextLengths[ollamaContextKey(baseURL, tag.Name)]
+		ollamaContextMu.Unlock()
+		if ok && entry.modifiedAt.Equal(tag.ModifiedAt) {
+			model.ContextLength = entry.length
+		} else if length, err := refreshOllamaContextLength(ctx, baseURL, tag.Name, tag.ModifiedAt); err == nil {
+			// A model without details still belongs in the list
+			model.ContextLength = length
+		}
+		models = append(models, model)
+	}
+	return models, nil
+}
+
+// ListOllamaModels returns the models installed in the Ollama server at
+// baseURL, or the default local server when it is empty
+func (a *App) ListOllamaModels(baseURL string) ([]OllamaModel, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return listOllamaModels(ctx, baseURL)
+}
+
+// replyTokens returns the tokens reserved for the answer
+func replyTokens(request ChatRequest) int {
+	if request.MaxTokens > 0 {
+		return request.MaxTokens
+	}
+	return ollamaReplyTokens
+}
+
+// checkContext estimates whether a chat fits in a context window
+func checkContext(request ChatRequest, contextLength int) (*ContextCheck, error) {
+	split, err := buildSplitPrompt(request.Prompt)
+	if err != nil {
+		return nil, err
+	}
+	check := &ContextCheck{
+		Model:         request.Model,
+		ContextLength: contextLength,
+		PromptTokens:  split.tokens(),
+		ReplyTokens:   replyTokens(request),
+	}
+	if contextLength > 0 && check.PromptTokens+check.ReplyTokens > contextLength {
+		check.Exceeded = true
+		check.Warning = fmt.Sprintf("The prompt needs about %d tokens plus %d for the answer, but %s accepts %d",
+			check.PromptTokens, check.ReplyTokens, request.Model, contextLength)
+	}
+	return check, nil
+}
+
+// CheckOllamaContext reports whether a prompt fits the context window of the
+// selected Ollama model, so the UI can warn before the chat is sent
+func (a *App) CheckOllamaContext(request ChatRequest) (*ContextCheck, error) {
+	if request.Model == "" {
+		return nil, fmt.Errorf("a model is required")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	length, err := ollamaContextLength(ctx, request.BaseURL, request.Model)
+	if err != nil {
+		return nil, err
+	}
+	return checkContext(request, length)
+}
+
+func (ollamaProvider) streamChat(ctx context.Context, request ChatRequest, split *splitPrompt, onDelta func(text string)) (*ChatResponse, error) {
+	messages := newOpenAIChatRequest(request.exportRequest(), split)
+	flattenOpenAIContent(messages)
+	body := ollamaChatRequest{
+		Model:    request.Model,
+		Messages: messages.Messages,
+		Stream:   true,
+		Options:  ollamaOptions{NumPredict: request.MaxTokens, Temperature: request.Temperature},
+	}
+
+	contextLength, err := ollamaContextLength(ctx, request.BaseURL, request.Model)
+	if err != nil {
+		return nil, err
+	}
+	if contextLength > 0 {
+		needed := split.tokens() + replyTokens(request)
+		if needed > contextLength {
+			return nil, fmt.Errorf("the prompt needs about %d tokens but %s accepts %d", needed, request.Model, contextLength)
+		}
+		window := needed + int(float64(needed)*ollamaContextHeadroom)
+		body.Options.NumCtx = min((window+ollamaContextStep-1)/ollamaContextStep*ollamaContextStep, contextLength)
+	}
+
+	httpResponse, err := ollamaPost(ctx, request.BaseURL, "/api/chat", body)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	// Ollama streams one JSON object per line
+	response := &ChatResponse{Model: request.Model}
+	var content strings.Builder
+	done := false
+	scanner := bufio.NewScanner(httpResponse.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var chunk ollamaChatChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return nil, fmt.Errorf("error parsing response stream: %v", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("ollama error: %s", chunk.Error)
+		}
+		if chunk.Model != "" {
+			response.Model = chunk.Model
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			onDelta(chunk.Message.Content)
+		}
+		if chunk.Done {
+			response.FinishReason = chunk.DoneReason
+			response.Usage.InputTokens = chunk.PromptEvalCount
+			response.Usage.OutputTokens = chunk.EvalCount
+			done = true
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading response stream: %v", err)
+	}
+	if !done {
+		return nil, errIncompleteStream
+	}
+	response.Content = content.String()
+	return response, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// ollamaStub serves /api/tags, /api/show and /api/chat like an Ollama server
+type ollamaStub struct {
+	*httptest.Server
+	modifiedAt    string
+	contextLength int
+	showCalls     atomic.Int32
+	chat          ollamaChatRequest
+	// cutOff ends the chat stream before the done line
+	cutOff bool
+}
+
+func newOllamaStub(t *testing.T) *ollamaStub {
+	stub := &ollamaStub{modifiedAt: "2025-01-02T03:04:05Z", contextLength: 8192}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			fmt.Fprintf(w, `{"models":[{"name":"llama3:8b","size":4661224676,"modified_at":%q,"details":{"family":"llama","parameter_size":"8.0B","quantization_level":"Q4_0"}}]}`, stub.modifiedAt)
+		case "/api/show":
+			stub.showCalls.Add(1)
+			var show struct {
+				Model string `json:"model"`
+			}
+			json.NewDecoder(r.Body).Decode(&show)
+			if show.Model != "llama3:8b" {
+				http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+				return
+			}
+			fmt.Fprintf(w, `{"model_info":{"general.architecture":"llama","general.parameter_count":8030261248,"llama.context_length":%d}}`, stub.contextLength)
+		case "/api/chat":
+			if err := json.NewDecoder(r.Body).Decode(&stub.chat); err != nil {
+				t.Errorf("error decoding chat request: %v", err)
+			}
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			fmt.Fprintln(w, `{"model":"llama3:8b","message":{"role":"assistant","content":"Hel"},"done":false}`)
+			fmt.Fprintln(w, `{"model":"llama3:8b","message":{"role":"assistant","content":"lo"},"done":false}`)
+			if stub.cutOff {
+				return
+			}
+			fmt.Fprintln(w, `{"model":"llama3:8b","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":26,"eval_count":2}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func TestListOllamaModels(t *testing.T) {
+	stub := newOllamaStub(t)
+	models, err := listOllamaModels(context.Background(), stub.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 1 {
+		t.Fatalf("models = %+v", models)
+	}
+	model := models[0]
+	if model.Name != "llama3:8b" || model.Family != "llama" || model.ParameterSize != "8.0B" || model.Quantization != "Q4_0" || model.ContextLength != 8192 {
+		t.Errorf("unexpected model: %+v", model)
+	}
+
+	// The context length is cached until the model is pulled again
+	if _, err := listOllamaModels(context.Background(), stub.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	if length, err := ollamaContextLength(context.Background(), stub.URL, "llama3:8b"); err != nil || length != 8192 {
+		t.Errorf("cached length = %d, %v", length, err)
+	}
+	if calls := stub.showCalls.Load(); calls != 1 {
+		t.Errorf("/api/show called %d times, want 1", calls)
+	}
+	stub.modifiedAt, stub.contextLength = "2025-02-03T04:05:06Z", 32768
+	models, err = listOllamaModels(context.Background(), stub.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if models[0].ContextLength != 32768 || stub.showCalls.Load() != 2 {
+		t.Errorf("the length of a pulled model was not refreshed: %d after %d calls", models[0].ContextLength, stub.showCalls.Load())
+	}
+}
+
+func TestOllamaStreamChat(t *testing.T) {
+	stub := newOllamaStub(t)
+	request := testChatRequest("ollama", stub.URL)
+	request.Model = "llama3:8b"
+	var deltas []string
+	response, err := streamTestChat(t, ollamaProvider{}, context.Background(), request, func(text string) {
+		deltas = append(deltas, text)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deltas, "|") != "Hel|lo" || response.Content != "Hello" || response.FinishReason != "stop" {
+		t.Errorf("deltas = %q, content = %q, finish reason = %q", deltas, response.Content, response.FinishReason)
+	}
+	if response.Usage != (ChatUsage{InputTokens: 26, OutputTokens: 2}) {
+		t.Errorf("usage = %+v", response.Usage)
+	}
+	// The window covers the prompt and the reply with some headroom,
+	// rounded up to a step and capped at the model's context length
+	if stub.chat.Options.NumCtx != ollamaContextStep || stub.chat.Options.NumPredict != 100 || !stub.chat.Stream {
+		t.Errorf("unexpected options: %+v", stub.chat.Options)
+	}
+	for _, test := range []struct{ maxTokens, numCtx int }{
+		{4000, 5 * ollamaContextStep},
+		{7500, 8192},
+	} {
+		request := request
+		request.MaxTokens = test.maxTokens
+		if _, err := streamTestChat(t, ollamaProvider{}, context.Background(), request, func(string) {}); err != nil {
+			t.Fatal(err)
+		}
+		if stub.chat.Options.NumCtx != test.numCtx {
+			t.Errorf("max tokens %d: num_ctx = %d, want %d", test.maxTokens, stub.chat.Options.NumCtx, test.numCtx)
+		}
+	}
+
+	// A second chat reuses the context length
+	if _, err := streamTestChat(t, ollamaProvider{}, context.Background(), request, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := stub.showCalls.Load(); calls != 1 {
+		t.Errorf("/api/show called %d times, want 1", calls)
+	}
+
+	request.MaxTokens = 10000
+	if _, err := streamTestChat(t, ollamaProvider{}, context.Background(), request, func(string) {}); err == nil || !strings.Contains(err.Error(), "accepts 8192") {
+		t.Errorf("error = %v, want the context window to be exceeded", err)
+	}
+}
+
+func TestOllamaStreamChatCutOff(t *testing.T) {
+	stub := newOllamaStub(t)
+	stub.cutOff = true
+	request := testChatRequest("ollama", stub.URL)
+	request.Model = "llama3:8b"
+	if _, err := streamTestChat(t, ollamaProvider{}, context.Background(), request, func(string) {}); !errors.Is(err, errIncompleteStream) {
+		t.Errorf("error = %v, want an incomplete stream", err)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// openAIProvider talks to any OpenAI compatible /chat/completions endpoint:
+// OpenAI itself, vLLM, the llama.cpp server or LM Studio
+type openAIProvider struct{}
+
+func (openAIProvider) defaultBaseURL() string { return defaultOpenAIBaseURL }
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIStreamChunk struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens        int `json:"prompt_tokens"`
+		CompletionTokens    int `json:"completion_tokens"`
+		PromptTokensDetails *struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"prompt_tokens_details"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// defaultBaseURLs are the hosted endpoints that stored and environment keys
+// belong to
+var defaultBaseURLs = map[string]string{
+	"openai":    defaultOpenAIBaseURL,
+	"anthropic": defaultAnthropicBaseURL,
+}
+
+// usesDefaultEndpoint reports whether a request goes to its provider's own
+// host. Stored and environment keys are only sent there, so a custom base URL
+// cannot collect them; other endpoints need a key in the request.
+func usesDefaultEndpoint(request ChatRequest) bool {
+	defaultBaseURL, ok := defaultBaseURLs[request.Provider]
+	if !ok {
+		return false
+	}
+	if request.BaseURL == "" {
+		return true
+	}
+	base, err := url.Parse(request.BaseURL)
+	if err != nil {
+		return false
+	}
+	defaultBase, _ := url.Parse(defaultBaseURL)
+	return base.Scheme == defaultBase.Scheme && strings.EqualFold(base.Host, defaultBase.Host)
+}
+
+// providerAPIKey returns the key sent with a request, falling back to an
+// environment variable for the provider's default endpoint
+func providerAPIKey(request ChatRequest, envName string) string {
+	if request.APIKey != "" || !usesDefaultEndpoint(request) {
+		return request.APIKey
+	}
+	return os.Getenv(envName)
+}
+
+// providerURL joins the base URL, default or configured, with an endpoint path
+func providerURL(request ChatRequest, defaultBaseURL string, path string) string {
+	base := request.BaseURL
+	if base == "" {
+		base = defaultBaseURL
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+// exportRequest returns the export settings the request body is built from
+func (r ChatRequest) exportRequest() ExportRequest {
+	return ExportRequest{
+		Prompt:        r.Prompt,
+		Provider:      r.Provider,
+		Model:         r.Model,
+		MaxTokens:     r.MaxTokens,
+		Temperature:   r.Temperature,
+		PromptCaching: r.PromptCaching,
+	}
+}
+
+// flattenOpenAIContent joins content parts into plain strings, since several
+// compatible servers only accept string content
+func flattenOpenAIContent(body *openAIChatRequest) {
+	for i, message := range body.Messages {
+		parts, ok := message.Content.([]openAIContentPart)
+		if !ok {
+			continue
+		}
+		texts := make([]string, len(parts))
+		for j, part := range parts {
+			texts[j] = part.Text
+		}
+		body.Messages[i].Content = strings.Join(texts, "\n\n")
+	}
+}
+
+func (openAIProvider) streamChat(ctx context.Context, request ChatRequest, split *splitPrompt, onDelta func(text string)) (*ChatResponse, error) {
+	body := newOpenAIChatRequest(request.exportRequest(), split)
+	body.Stream = true
+	body.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	flattenOpenAIContent(body)
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request body: %v", err)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, providerURL(request, defaultOpenAIBaseURL, "/chat/completions"), bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Accept", "text/event-stream")
+	// Local servers usually need no key
+	if key := providerAPIKey(request, "OPENAI_API_KEY"); key != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	httpResponse, err := sendProviderRequest(httpRequest, request.Provider, split.tokens()+request.MaxTokens)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	response := &ChatResponse{Model: request.Model}
+	var content strings.Builder
+	err = readSSE(httpResponse.Body, func(event string, data string) error {
+		if data == "[DONE]" {
+			return io.EOF
+		}
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("error parsing response stream: %v", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("%s error: %s", request.Provider, chunk.Error.Message)
+		}
+		if chunk.Model != "" {
+			response.Model = chunk.Model
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				onDelta(choice.Delta.Content)
+			}
+			if choice.FinishReason != nil {
+				response.FinishReason = *choice.FinishReason
+			}
+		}
+		if chunk.Usage != nil {
+			response.Usage.InputTokens = chunk.Usage.PromptTokens
+			response.Usage.OutputTokens = chunk.Usage.CompletionTokens
+			if chunk.Usage.PromptTokensDetails != nil {
+				response.Usage.CacheReadTokens = chunk.Usage.PromptTokensDetails.CachedTokens
+			}
+		}
+		return nil
+	})
+	// Some compatible servers omit [DONE], so a finish reason also marks
+	// a complete reply
+	if err == errIncompleteStream && response.FinishReason != "" {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	response.Content = content.String()
+	return response, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testChatRequest(provider string, baseURL string) ChatRequest {
+	return ChatRequest{
+		Provider:  provider,
+		Model:     "test-model",
+		BaseURL:   baseURL,
+		APIKey:    "test-key",
+		MaxTokens: 100,
+		Prompt:    PromptRequest{Format: defaultExportFormat, Instructions: "Be brief.", RawPrompt: "Say hello."},
+	}
+}
+
+// streamTestChat builds the prompt of a request and streams it from a provider
+func streamTestChat(t *testing.T, provider chatProvider, ctx context.Context, request ChatRequest, onDelta func(text string)) (*ChatResponse, error) {
+	t.Helper()
+	split, err := buildSplitPrompt(request.Prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider.streamChat(ctx, request, split, onDelta)
+}
+
+func writeSSE(w http.ResponseWriter, events ...string) {
+	for _, event := range events {
+		fmt.Fprintf(w, "%s\n\n", event)
+	}
+	w.(http.Flusher).Flush()
+}
+
+func TestOpenAIStreamChat(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("error decoding request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeSSE(w,
+			`: keep-alive`,
+			`data: {"id":"c1","model":"test-model-2025","choices":[{"delta":{"content":"Hel"}}]}`,
+			`data: {"id":"c1","choices":[{"delta":{"content":"lo"},"finish_reason":"stop"}]}`,
+			`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":42,"completion_tokens":2,"prompt_tokens_details":{"cached_tokens":32}}}`,
+			`data: [DONE]`,
+		)
+	}))
+	defer server.Close()
+
+	var deltas []string
+	response, err := streamTestChat(t, openAIProvider{}, context.Background(), testChatRequest("openai", server.URL+"/v1/"), func(text string) {
+		deltas = append(deltas, text)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deltas, "|") != "Hel|lo" || response.Content != "Hello" {
+		t.Errorf("deltas = %q, content = %q", deltas, response.Content)
+	}
+	if response.Model != "test-model-2025" || response.FinishReason != "stop" {
+		t.Errorf("model = %q, finish reason = %q", response.Model, response.FinishReason)
+	}
+	want := ChatUsage{InputTokens: 42, OutputTokens: 2, CacheReadTokens: 32}
+	if response.Usage != want {
+		t.Errorf("usage = %+v, want %+v", response.Usage, want)
+	}
+
+	if body["stream"] != true || body["model"] != "test-model" {
+		t.Errorf("unexpected request body: %v", body)
+	}
+	if options, _ := body["stream_options"].(map[string]interface{}); options["include_usage"] != true {
+		t.Errorf("usage was not requested: %v", body["stream_options"])
+	}
+	messages, _ := body["messages"].([]interface{})
+	if len(messages) == 0 {
+		t.Fatalf("no messages sent: %v", body)
+	}
+	for _, message := range messages {
+		if _, ok := message.(map[string]interface{})["content"].(string); !ok {
+			t.Errorf("content was not flattened to a string: %v", message)
+		}
+	}
+}
+
+func TestOpenAIStreamChatErrors(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		status     int
+		header     map[string]string
+		body       string
+		kind       string
+		statusCode int
+	}{
+		{name: "auth", status: http.StatusUnauthorized, body: `{"error":{"message":"bad key"}}`, kind: ProviderErrorAuth, statusCode: 401},
+		{name: "request", status: http.StatusBadRequest, body: `{"error":{"message":"context too long"}}`, kind: ProviderErrorRequest, statusCode: 400},
+		// A Retry-After beyond maxRetryAfter fails at once instead of waiting
+		{name: "rate limited", status: http.StatusTooManyRequests, header: map[string]string{"Retry-After": "600"}, kind: ProviderErrorRateLimited, statusCode: 429},
+		{name: "stream error", status: http.StatusOK, body: "data: {\"error\":{\"message\":\"server exploded\"}}\n\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				for key, value := range test.header {
+					w.Header().Set(key, value)
+				}
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			}))
+			defer server.Close()
+
+			_, err := streamTestChat(t, openAIProvider{}, context.Background(), testChatRequest("openai", server.URL), func(string) {})
+			if err == nil {
+				t.Fatal("no error returned")
+			}
+			if calls != 1 {
+				t.Errorf("calls = %d, want 1", calls)
+			}
+			if test.kind == "" {
+				if !strings.Contains(err.Error(), "server exploded") {
+					t.Errorf("error = %v", err)
+				}
+				return
+			}
+			var providerErr *ProviderError
+			if !errors.As(err, &providerErr) {
+				t.Fatalf("error is not a ProviderError: %v", err)
+			}
+			if providerErr.Kind != test.kind || providerErr.StatusCode != test.statusCode {
+				t.Errorf("kind = %s, status = %d, want %s, %d", providerErr.Kind, providerErr.StatusCode, test.kind, test.statusCode)
+			}
+		})
+	}
+}
+
+func TestOpenAIStreamChatCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeSSE(w, `data: {"choices":[{"delta":{"content":"partial"}}]}`)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := streamTestChat(t, openAIProvider{}, ctx, testChatRequest("openai", server.URL), func(text string) {
+		if text == "partial" {
+			cancel()
+		}
+	})
+	if err == nil {
+		t.Fatal("cancelled chat returned no error")
+	}
+	if ctx.Err() == nil {
+		t.Fatal("the context was not cancelled")
+	}
+}
+
+func TestProviderAPIKeyStaysOnDefaultHost(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "env-key")
+	for _, test := range []struct {
+		request ChatRequest
+		want    bool
+	}{
+		{ChatRequest{Provider: "openai"}, true},
+		{ChatRequest{Provider: "openai", BaseURL: "https://api.openai.com/v1/"}, true},
+		{ChatRequest{Provider: "openai", BaseURL: "https://API.openai.com"}, true},
+		{ChatRequest{Provider: "openai", BaseURL: "http://api.openai.com/v1"}, false},
+		{ChatRequest{Provider: "openai", BaseURL: "https://api.openai.com.example.net/v1"}, false},
+		{ChatRequest{Provider: "openai", BaseURL: "http://localhost:8080/v1"}, false},
+		{ChatRequest{Provider: "anthropic", BaseURL: "https://api.openai.com/v1"}, false},
+		{ChatRequest{Provider: "ollama"}, false},
+	} {
+		if got := usesDefaultEndpoint(test.request); got != test.want {
+			t.Errorf("usesDefaultEndpoint(%s, %q) = %v, want %v", test.request.Provider, test.request.BaseURL, got, test.want)
+		}
+	}
+
+	if key := providerAPIKey(ChatRequest{Provider: "openai"}, "OPENAI_API_KEY"); key != "env-key" {
+		t.Errorf("default endpoint key = %q, want the environment key", key)
+	}
+	authorization := "unset"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		writeSSE(w, `data: [DONE]`)
+	}))
+	defer server.Close()
+	request := testChatRequest("openai", server.URL)
+	request.APIKey = ""
+	if _, err := streamTestChat(t, openAIProvider{}, context.Background(), request, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		t.Errorf("the environment key was sent to a custom endpoint: %q", authorization)
+	}
+}
+
+func TestOpenAIStreamChatCutOff(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		events []string
+		ok     bool
+	}{
+		{name: "cut off", events: []string{`data: {"choices":[{"delta":{"content":"Hel"}}]}`}},
+		{name: "finish reason without done", events: []string{`data: {"choices":[{"delta":{"content":"Hello"},"finish_reason":"stop"}]}`}, ok: true},
+		{name: "done", events: []string{`data: {"choices":[{"delta":{"content":"Hello"}}]}`, `data: [DONE]`}, ok: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				writeSSE(w, test.events...)
+			}))
+			defer server.Close()
+
+			_, err := streamTestChat(t, openAIProvider{}, context.Background(), testChatRequest("openai", server.URL), func(string) {})
+			if test.ok && err != nil {
+				t.Errorf("error = %v", err)
+			}
+			if !test.ok && !errors.Is(err, errIncompleteStream) {
+				t.Errorf("error = %v, want an incomplete stream", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultTreeCollapseThreshold = 25
+
+// TreeOptions controls the project tree section of a prompt
+type TreeOptions struct {
+	// Roots are the folders to draw; when empty the common folder of the selected files is used
+	Roots        []string `json:"roots,omitempty"`
+	MaxDepth     int      `json:"maxDepth,omitempty"`
+	MarkIncluded bool     `json:"markIncluded"`
+	// CollapseThreshold is the number of files above which a folder only lists
+	// its included files and a "(+N files)" summary
+	CollapseThreshold int `json:"collapseThreshold,omitempty"`
+}
+
+// treeEntry is a file or folder found while drawing the tree
+type treeEntry struct {
+	name  string
+	path  string
+	isDir bool
+}
+
+// projectTree draws folders like the tree command, leaving out what the
+// folder scanner skips
+type projectTree struct {
+	options  TreeOptions
+	included map[string]bool
+	rules    *scanRules
+}
+
+// RenderProjectTree draws the project tree for the given options
+func (a *App) RenderProjectTree(options TreeOptions, selectedPaths []string) (string, error) {
+	return renderProjectTrees(options, selectedPaths)
+}
+
+// renderProjectTrees draws one tree per root, marking the selected paths
+func renderProjectTrees(options TreeOptions, selectedPaths []string) (string, error) {
+	if options.CollapseThreshold <= 0 {
+		options.CollapseThreshold = defaultTreeCollapseThreshold
+	}
+
+	included := map[string]bool{}
+	for _, selected := range selectedPaths {
+		if absolutePath, err := filepath.Abs(selected); err == nil {
+			included[absolutePath] = true
+		}
+	}
+
+	roots := options.Roots
+	if len(roots) == 0 {
+		root := commonDirectory(selectedPaths)
+		if root == "" {
+			return "", nil
+		}
+		roots = []string{root}
+	}
+
+	var trees []string
+	for _, root := range roots {
+		absoluteRoot, err := filepath.Abs(root)
+		if err != nil {
+			return "", fmt.Errorf("error getting absolute path of folder: %v", err)
+		}
+		// The scan reports the warnings about the configuration
+		rules, _, err := newScanRules(absoluteRoot, defaultFolderConfig)
+		if err != nil {
+			return "", err
+		}
+		tree := &projectTree{options: options, included: included, rules: rules}
+		var b strings.Builder
+		b.WriteString(filepath.Base(absoluteRoot) + "/\n")
+		if err := tree.render(&b, absoluteRoot, "", 1); err != nil {
+			return "", err
+		}
+		trees = append(trees, strings.TrimSuffix(b.String(), "\n"))
+	}
+	return strings.Join(trees, "\n\n"), nil
+}
+
+// entries lists a folder without skipped entries, folders first. Included
+// files are always listed.
+func (t *projectTree) entries(dir string) ([]treeEntry, error) {
+	t.rules.loadDir(dir)
+
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading folder %s: %v", dir, err)
+	}
+
+	var entries []treeEntry
+	for _, item := range items {
+		if isAlwaysIgnored(item.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, item.Name())
+		info, err := item.Info()
+		if err != nil {
+			continue
+		}
+		if !t.included[path] && t.rules.skip(path, info) != nil {
+			continue
+		}
+		entries = append(entries, treeEntry{name: item.Name(), path: path, isDir: item.IsDir()})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].isDir != entries[j].isDir {
+			return entries[i].isDir
+		}
+		return entries[i].name < entries[j].name
+	})
+	return entries, nil
+}
+
+func (t *projectTree) render(b *strings.Builder, dir string, prefix string, depth int) error {
+	entries, err := t.entries(dir)
+	if err != nil {
+		return err
+	}
+
+	// Large folders only keep their subfolders and included files
+	var files, hidden int
+	for _, entry := range entries {
+		if !entry.isDir {
+			files++
+		}
+	}
+	if files > t.options.CollapseThreshold {
+		var kept []treeEntry
+		for _, entry := range entries {
+			if entry.isDir || t.included[entry.path] {
+				kept = append(kept, entry)
+			} else {
+				hidden++
+			}
+		}
+		entries = kept
+	}
+
+	lines := len(entries)
+	if hidden > 0 {
+		lines++
+	}
+	for i, entry := range entries {
+		branch, indent := "├── ", "│   "
+		if i == lines-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		if !entry.isDir {
+			b.WriteString(prefix + branch + entry.name)
+			if t.options.MarkIncluded && t.included[entry.path] {
+				b.WriteString(" *")
+			}
+			b.WriteString("\n")
+			continue
+		}
+
+		// Folders past the depth limit are summarised unless they lead to an included file
+		if t.options.MaxDepth > 0 && depth >= t.options.MaxDepth && !t.containsIncluded(entry.path) {
+			count, err := t.countFiles(entry.path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(b, "%s%s%s/ (+%d files)\n", prefix, branch, entry.name, count)
+			continue
+		}
+		b.WriteString(prefix + branch + entry.name + "/\n")
+		if err := t.render(b, entry.path, prefix+indent, depth+1); err != nil {
+			return err
+		}
+	}
+	if hidden > 0 {
+		fmt.Fprintf(b, "%s└── (+%d files)\n", prefix, hidden)
+	}
+	return nil
+}
+
+// containsIncluded reports whether any included file lives below dir
+func (t *projectTree) containsIncluded(dir string) bool {
+	prefix := dir + string(filepath.Separator)
+	for path := range t.included {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// countFiles counts the files below a folder that are not ignored
+func (t *projectTree) countFiles(dir string) (int, error) {
+	entries, err := t.entries(dir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, entry := range entries {
+		if !entry.isDir {
+			count++
+			continue
+		}
+		n, err := t.countFiles(entry.path)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// commonDirectory returns the deepest folder containing all the given paths
+func commonDirectory(paths []string) string {
+	var common []string
+	for i, path := range paths {
+		absolutePath, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		parts := strings.Split(filepath.Dir(absolutePath), string(filepath.Separator))
+		if i == 0 || common == nil {
+			common = parts
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(parts) && common[n] == parts[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	if len(common) == 0 {
+		return ""
+	}
+	if len(common) == 1 && common[0] == "" {
+		return string(filepath.Separator)
+	}
+	return strings.Join(common, string(filepath.Separator))
+}
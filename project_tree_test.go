@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProjectTreeUsesScanRules(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	files := map[string]string{
+		"main.go":                 "package main",
+		"debug.log":               "noise",
+		"secret.txt":              "ignored by .gitignore",
+		".gitignore":              "secret.txt\n",
+		".code-prompter.yaml":     "ignoreFolders: [generated]\n",
+		"node_modules/dep/dep.js": "module.exports = {}",
+		"generated/api.go":        "package generated",
+		"internal/util.go":        "package internal",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tree, err := renderProjectTrees(TreeOptions{Roots: []string{root}}, []string{filepath.Join(root, "debug.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"main.go", "internal/", "util.go", "debug.log"} {
+		if !strings.Contains(tree, want) {
+			t.Errorf("tree is missing %s:\n%s", want, tree)
+		}
+	}
+	for _, skipped := range []string{"secret.txt", "node_modules", "generated", ".code-prompter.yaml", ".gitignore"} {
+		if strings.Contains(tree, skipped) {
+			t.Errorf("tree lists %s, which the scanner skips:\n%s", skipped, tree)
+		}
+	}
+
+	// The scanner leaves out the same paths
+	scan, err := scanFolder(root, defaultFolderConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Files) != 2 {
+		t.Errorf("scanned files = %v, want main.go and internal/util.go", scan.Files)
+	}
+}
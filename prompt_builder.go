@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// SelectedFile is a file chosen for the prompt. Without ranges or symbols the
+// whole file is included; otherwise only those regions are, widened by
+// ContextLines and shown with their original line numbers.
+type SelectedFile struct {
+	Path         string      `json:"path"`
+	Content      string      `json:"content,omitempty"`
+	Ranges       []LineRange `json:"ranges,omitempty"`
+	Symbols      []string    `json:"symbols,omitempty"`
+	ContextLines int         `json:"contextLines,omitempty"`
+}
+
+// PromptRequest is everything needed to assemble a prompt
+type PromptRequest struct {
+	Format       string         `json:"format"`
+	Task         string         `json:"task"`
+	Instructions string         `json:"instructions"`
+	RawPrompt    string         `json:"rawPrompt"`
+	Diff         string         `json:"diff,omitempty"`
+	Files        []SelectedFile `json:"files"`
+	Minify       *MinifyOptions `json:"minify,omitempty"`
+	Tree         *TreeOptions   `json:"tree,omitempty"`
+	// FileAttributes adds language, lines, tokens and truncated attributes to XML files
+	FileAttributes bool `json:"fileAttributes,omitempty"`
+	// Note is shown ahead of the files, e.g. to say what an incremental
+	// prompt leaves out
+	Note string `json:"note,omitempty"`
+}
+
+// PromptResult is a built prompt and its estimated size
+type PromptResult struct {
+	Prompt string            `json:"prompt"`
+	Tokens int               `json:"tokens"`
+	Files  []FileTokenReport `json:"files"`
+	// HistoryID identifies the prompt in the history when it was recorded
+	HistoryID string `json:"historyId,omitempty"`
+	// Manifest records the files and settings needed to rebuild the prompt
+	Manifest *PromptManifest `json:"manifest,omitempty"`
+}
+
+// promptFile is a selected file ready to be rendered
+type promptFile struct {
+	Path     string
+	Language string
+	Content  string
+	Lines    int
+	Ranges   []LineRange
+	// Before is the estimated token count prior to minification
+	Before int
+}
+
+// Excerpt reports whether only some regions of the file are included
+func (f promptFile) Excerpt() bool {
+	return len(f.Ranges) > 0
+}
+
+// promptData is the format independent content of a prompt
+type promptData struct {
+	Task           string
+	Instructions   string
+	RawPrompt      string
+	Note           string
+	Tree           string
+	Diff           string
+	Files          []promptFile
+	FileAttributes bool
+}
+
+// promptRenderer turns prompt data into the text of one output format
+type promptRenderer func(data *promptData) (string, error)
+
+// promptFormats maps format names to their renderers
+var promptFormats = map[string]promptRenderer{
+	"chatgpt":  renderChatGPTPrompt,
+	"claude":   renderClaudePrompt,
+	"markdown": renderMarkdownPrompt,
+	"chatml":   renderChatMLPrompt,
+	"llama3":   renderLlama3Prompt,
+	"mistral":  renderMistralPrompt,
+	"gemma":    renderGemmaPrompt,
+}
+
+// BuildPrompt assembles the prompt for the selected files in the requested
+// format and records it in the prompt history
+func (a *App) BuildPrompt(request PromptRequest) (*PromptResult, error) {
+	result, err := buildPrompt(request)
+	if err != nil {
+		return nil, err
+	}
+	a.recordPrompt(request, result)
+	return result, nil
+}
+
+// recordPrompt attaches the manifest to a generated prompt and adds it to the
+// history. Failures are only logged, the prompt itself is still usable.
+func (a *App) recordPrompt(request PromptRequest, result *PromptResult) {
+	manifest, err := buildManifest(request)
+	if err != nil {
+		runtime.LogWarning(a.ctx, fmt.Sprintf("Error building prompt manifest: %v", err))
+	}
+	result.Manifest = manifest
+
+	entry, err := a.history.add(request, result, nil)
+	if err != nil {
+		runtime.LogWarning(a.ctx, fmt.Sprintf("Error saving prompt history: %v", err))
+		return
+	}
+	result.HistoryID = entry.ID
+}
+
+// PromptFormats lists the names of the built-in and user defined output formats
+func (a *App) PromptFormats() []string {
+	names := make([]string, 0, len(promptFormats))
+	for name := range promptFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append(names, listUserFormats()...)
+}
+
+// resolvePromptFormat finds a built-in format, then a user defined template
+func resolvePromptFormat(format string) (promptRenderer, error) {
+	if render, ok := promptFormats[strings.ToLower(format)]; ok {
+		return render, nil
+	}
+	render, found, err := loadUserFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown prompt format: %s", format)
+	}
+	return render, nil
+}
+
+func buildPrompt(request PromptRequest) (*PromptResult, error) {
+	render, err := resolvePromptFormat(request.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := preparePromptData(request)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt, err := render(data)
+	if err != nil {
+		return nil, err
+	}
+	result := &PromptResult{Prompt: prompt, Tokens: estimateTokens(prompt)}
+	for _, file := range data.Files {
+		result.Files = append(result.Files, FileTokenReport{Path: file.Path, Before: file.Before, After: estimateTokens(file.Content)})
+	}
+	return result, nil
+}
+
+// preparePromptData loads the selected files and extracts their regions
+func preparePromptData(request PromptRequest) (*promptData, error) {
+	data := &promptData{
+		Task:           request.Task,
+		Instructions:   request.Instructions,
+		RawPrompt:      request.RawPrompt,
+		Note:           request.Note,
+		Diff:           request.Diff,
+		FileAttributes: request.FileAttributes,
+	}
+
+	for _, file := range request.Files {
+		pf, err := preparePromptFile(file, request.Minify)
+		if err != nil {
+			return nil, err
+		}
+		data.Files = append(data.Files, pf)
+	}
+
+	if request.Tree != nil {
+		paths := make([]string, len(request.Files))
+		for i, file := range request.Files {
+			paths[i] = file.Path
+		}
+		tree, err := renderProjectTrees(*request.Tree, paths)
+		if err != nil {
+			return nil, err
+		}
+		if tree != "" && request.Tree.MarkIncluded {
+			tree += "\n\n(* marks files included in this prompt)"
+		}
+		data.Tree = tree
+	}
+	return data, nil
+}
+
+// preparePromptFile loads a selected file and returns the text sent for it:
+// the excerpt of its regions, or the whole file, minified when asked
+func preparePromptFile(file SelectedFile, minify *MinifyOptions) (promptFile, error) {
+	content, err := readSelectedFile(file)
+	if err != nil {
+		return promptFile{}, err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	pf := promptFile{Path: file.Path, Language: detectFileLanguage(file.Path, content), Content: content, Lines: len(lines)}
+
+	if len(file.Ranges) > 0 || len(file.Symbols) > 0 {
+		ranges, err := selectFileRegions(file, lines)
+		if err != nil {
+			return promptFile{}, err
+		}
+		pf.Ranges = ranges
+		pf.Content = renderExcerpt(lines, ranges)
+	}
+	pf.Before = estimateTokens(pf.Content)
+
+	// Excerpts keep their original lines so the line numbers stay truthful
+	if minify != nil && !pf.Excerpt() {
+		pf.Content = minifyContent(pf.Path, pf.Content, *minify)
+	}
+	return pf, nil
+}
+
+// readSelectedFile returns the content sent with a file, or reads it from disk
+func readSelectedFile(file SelectedFile) (string, error) {
+	if file.Content != "" {
+		return file.Content, nil
+	}
+	raw, err := os.ReadFile(file.Path)
+	if err != nil {
+		return "", fmt.Errorf("error reading file content: %v", err)
+	}
+	return string(raw), nil
+}
+
+// estimateTokens approximates the token count of text at four characters per token
+func estimateTokens(text string) int {
+	return (len([]rune(text)) + 3) / 4
+}
+
+// renderChatGPTPrompt renders the plain text format
+func renderChatGPTPrompt(data *promptData) (string, error) {
+	var b strings.Builder
+
+	if data.Task != "" {
+		fmt.Fprintf(&b, "Task:\n%s\n\n", data.Task)
+	}
+	if data.Instructions != "" {
+		fmt.Fprintf(&b, "Instructions:\n%s\n\n", data.Instructions)
+	}
+	if data.Note != "" {
+		fmt.Fprintf(&b, "Note:\n%s\n\n", data.Note)
+	}
+	if data.Tree != "" {
+		fmt.Fprintf(&b, "Project tree:\n%s\n\n", data.Tree)
+	}
+	if data.Diff != "" {
+		fmt.Fprintf(&b, "Diff:\n%s\n\n", strings.TrimSuffix(data.Diff, "\n"))
+	}
+
+	if len(data.Files) > 0 {
+		files := make([]string, len(data.Files))
+		for i, file := range data.Files {
+			header := "File: " + file.Path
+			if file.Excerpt() {
+				header += fmt.Sprintf(" (lines %s of %d)", formatRanges(file.Ranges), file.Lines)
+			}
+			files[i] = header + "\n" + file.Content
+		}
+		fmt.Fprintf(&b, "Files:\n%s\n\n", strings.Join(files, "\n\n"))
+	}
+
+	b.WriteString(data.RawPrompt)
+	return b.String(), nil
+}
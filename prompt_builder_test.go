@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenPromptData exercises every section a format can render
+func goldenPromptData() *promptData {
+	return &promptData{
+		Task:         "Review the code",
+		Instructions: "Answer in English",
+		RawPrompt:    "Why does main exit early?",
+		Note:         "util.go is unchanged & not repeated",
+		Tree:         "app/\n├── main.go *\n└── util.go",
+		Diff:         "--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n-package app\n+package main\n",
+		Files: []promptFile{
+			{Path: "app/main.go", Language: "go", Content: "package main\n\nfunc main() {}\n", Lines: 3},
+			{
+				Path:     "app/util.go",
+				Language: "go",
+				Content:  "...\n10 | func helper() <T> & \"x\" {}\n...",
+				Lines:    40,
+				Ranges:   []LineRange{{Start: 10, End: 10}},
+			},
+		},
+		FileAttributes: true,
+	}
+}
+
+func TestBuiltinFormatsGolden(t *testing.T) {
+	for name, render := range promptFormats {
+		t.Run(name, func(t *testing.T) {
+			got, err := render(goldenPromptData())
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join("testdata", "golden", name+".txt")
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("missing golden file, run go test -update: %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("%s output differs from %s\ngot:\n%s\nwant:\n%s", name, path, got, want)
+			}
+		})
+	}
+}
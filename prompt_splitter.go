@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitOptions limits the size of each part of a split prompt. MaxTokens
+// takes precedence over MaxChars when both are set.
+type SplitOptions struct {
+	MaxTokens int `json:"maxTokens,omitempty"`
+	MaxChars  int `json:"maxChars,omitempty"`
+}
+
+// PromptPart is one message of a prompt split into several
+type PromptPart struct {
+	Index   int    `json:"index"`
+	Total   int    `json:"total"`
+	Content string `json:"content"`
+	Tokens  int    `json:"tokens"`
+}
+
+// SplitPrompt builds the prompt and splits it into numbered parts that each
+// fit the limit, to be pasted one after another
+func (a *App) SplitPrompt(request PromptRequest, options SplitOptions) ([]PromptPart, error) {
+	return splitPromptParts(request, options)
+}
+
+// partPreamble tells the model to wait for the remaining parts
+func partPreamble(index int, total int) string {
+	if index == total {
+		return fmt.Sprintf("[Part %d/%d] This is the last part. You now have all the context, answer the task below.\n\n", index, total)
+	}
+	return fmt.Sprintf("[Part %d/%d] This prompt is split into %d parts. Do not answer yet, just reply \"OK\" and wait for the next part.\n\n", index, total, total)
+}
+
+// longestPreamble is the preamble used to reserve room in every part
+func longestPreamble() string {
+	return partPreamble(998, 999)
+}
+
+// promptSplitter packs the files of a prompt into parts under a size limit
+type promptSplitter struct {
+	render promptRenderer
+	limit  int
+	tokens bool
+}
+
+func (s *promptSplitter) size(text string) int {
+	if s.tokens {
+		return estimateTokens(text)
+	}
+	return len([]rune(text))
+}
+
+// fits reports whether the rendered part stays under the limit, allowing
+// for the longest preamble the part could get
+func (s *promptSplitter) fits(data *promptData) (bool, error) {
+	text, err := s.render(data)
+	if err != nil {
+		return false, err
+	}
+	return s.size(longestPreamble()+text) <= s.limit, nil
+}
+
+func splitPromptParts(request PromptRequest, options SplitOptions) ([]PromptPart, error) {
+	splitter := &promptSplitter{limit: options.MaxChars}
+	if options.MaxTokens > 0 {
+		splitter.limit, splitter.tokens = options.MaxTokens, true
+	}
+	if splitter.limit <= 0 {
+		return nil, fmt.Errorf("a token or character limit is required")
+	}
+
+	render, err := resolvePromptFormat(request.Format)
+	if err != nil {
+		return nil, err
+	}
+	splitter.render = render
+
+	data, err := preparePromptData(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// The context parts carry the files, the first one also the tree; the
+	// task goes last
+	contextOnly := func(files []promptFile, withTree bool) *promptData {
+		part := *data
+		part.Task, part.Instructions, part.RawPrompt, part.Diff, part.Note = "", "", "", "", ""
+		part.Files = files
+		if !withTree {
+			part.Tree = ""
+		}
+		return &part
+	}
+
+	var groups [][]promptFile
+	var current []promptFile
+	for _, file := range data.Files {
+		candidate := append(append([]promptFile{}, current...), file)
+		ok, err := splitter.fits(contextOnly(candidate, len(groups) == 0))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			current = candidate
+			continue
+		}
+		if len(current) > 0 {
+			groups = append(groups, current)
+			current = nil
+		}
+
+		// A file that does not fit on its own is cut into chunks of lines
+		if ok, _ := splitter.fits(contextOnly([]promptFile{file}, len(groups) == 0)); ok {
+			current = []promptFile{file}
+			continue
+		}
+		chunks, err := splitter.chunkFile(file, func(files []promptFile) *promptData {
+			return contextOnly(files, len(groups) == 0)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range chunks[:len(chunks)-1] {
+			groups = append(groups, []promptFile{chunk})
+		}
+		current = []promptFile{chunks[len(chunks)-1]}
+	}
+
+	// The last group shares the final part with the task when both fit
+	final := *data
+	final.Tree = ""
+	final.Files = current
+	if len(groups) == 0 {
+		final.Tree = data.Tree
+	}
+	if ok, err := splitter.fits(&final); err != nil {
+		return nil, err
+	} else if !ok && len(current) > 0 {
+		groups = append(groups, current)
+		final.Files, final.Tree = nil, ""
+		ok, err = splitter.fits(&final)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("the task, instructions and diff alone exceed the limit of %d", splitter.limit)
+		}
+	} else if !ok {
+		return nil, fmt.Errorf("the task, instructions and diff alone exceed the limit of %d", splitter.limit)
+	}
+
+	var parts []string
+	for i, group := range groups {
+		text, err := render(contextOnly(group, i == 0))
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, text)
+	}
+	text, err := render(&final)
+	if err != nil {
+		return nil, err
+	}
+	parts = append(parts, text)
+
+	result := make([]PromptPart, len(parts))
+	for i, text := range parts {
+		content := text
+		if len(parts) > 1 {
+			content = partPreamble(i+1, len(parts)) + text
+		}
+		result[i] = PromptPart{Index: i + 1, Total: len(parts), Content: content, Tokens: estimateTokens(content)}
+	}
+	return result, nil
+}
+
+// chunkFile cuts a file into consecutive runs of lines that each fit a part
+func (s *promptSplitter) chunkFile(file promptFile, contextOnly func([]promptFile) *promptData) ([]promptFile, error) {
+	empty := file
+	empty.Content = ""
+	empty.Path = fmt.Sprintf("%s (chunk 999/999)", file.Path)
+	overhead, err := s.render(contextOnly([]promptFile{empty}))
+	if err != nil {
+		return nil, err
+	}
+	budget := s.limit - s.size(longestPreamble()+overhead)
+	if budget <= 0 {
+		return nil, fmt.Errorf("the limit is too small to hold any of %s", file.Path)
+	}
+
+	var contents []string
+	var chunk strings.Builder
+	used := 0
+	for _, line := range strings.SplitAfter(file.Content, "\n") {
+		lineSize := s.size(line)
+		if used+lineSize > budget && chunk.Len() > 0 {
+			contents = append(contents, chunk.String())
+			chunk.Reset()
+			used = 0
+		}
+		// Lines longer than the whole budget are cut as well
+		for lineSize > budget {
+			cut := len(line) * budget / lineSize
+			for cut > 0 && !isRuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				break
+			}
+			contents = append(contents, line[:cut])
+			line = line[cut:]
+			lineSize = s.size(line)
+		}
+		chunk.WriteString(line)
+		used += lineSize
+	}
+	if chunk.Len() > 0 {
+		contents = append(contents, chunk.String())
+	}
+
+	chunks := make([]promptFile, len(contents))
+	for i, content := range contents {
+		chunk := file
+		chunk.Content = content
+		chunk.Path = fmt.Sprintf("%s (chunk %d/%d)", file.Path, i+1, len(contents))
+		chunks[i] = chunk
+	}
+	return chunks, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitPromptCarriesTreeOnce(t *testing.T) {
+	root := t.TempDir()
+	var files []SelectedFile
+	for _, name := range []string{"a.go", "b.go"} {
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, []byte("package main\n\nfunc "+strings.TrimSuffix(name, ".go")+"() {}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, SelectedFile{Path: path})
+	}
+
+	for _, limit := range []int{700, 900, 1200} {
+		parts, err := splitPromptParts(PromptRequest{
+			Format:    "markdown",
+			RawPrompt: strings.Repeat("Explain the code. ", 25),
+			Files:     files,
+			Tree:      &TreeOptions{Roots: []string{root}},
+		}, SplitOptions{MaxChars: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		trees := 0
+		for _, part := range parts {
+			trees += strings.Count(part.Content, "## Project tree")
+			if size := len([]rune(part.Content)); size > limit {
+				t.Errorf("limit %d: part %d has %d characters", limit, part.Index, size)
+			}
+		}
+		if trees != 1 {
+			t.Errorf("limit %d: the tree appears %d times in %d parts", limit, trees, len(parts))
+		}
+	}
+}
+
+func TestSplitPromptRejectsOversizedTask(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "a.go")
+	if err := os.WriteFile(path, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, files := range [][]SelectedFile{nil, {{Path: path}}} {
+		parts, err := splitPromptParts(PromptRequest{
+			Format:    "markdown",
+			RawPrompt: strings.Repeat("x", 2000),
+			Files:     files,
+		}, SplitOptions{MaxChars: 500})
+		if err == nil {
+			t.Errorf("%d files: a task over the limit was split into %d parts", len(files), len(parts))
+		}
+	}
+}
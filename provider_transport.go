@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// baseRetryDelay is the wait before the first retry; it doubles with each
+// attempt. Tests shorten it.
+var baseRetryDelay = time.Second
+
+const (
+	maxProviderAttempts = 4
+	maxRetryDelay       = 30 * time.Second
+	// A Retry-After longer than this fails the call instead of waiting
+	maxRetryAfter = time.Minute
+
+	// The circuit opens after this many consecutive failed calls and lets a
+	// single trial call through once the cooldown has passed
+	circuitFailureThreshold = 5
+	circuitCooldown         = 30 * time.Second
+)
+
+// Kinds of provider errors, so the UI can explain what went wrong
+const (
+	ProviderErrorRateLimited = "rate_limited"
+	ProviderErrorOverloaded  = "overloaded"
+	ProviderErrorServer      = "server"
+	ProviderErrorAuth        = "auth"
+	ProviderErrorRequest     = "request"
+	ProviderErrorNetwork     = "network"
+	ProviderErrorCircuitOpen = "circuit_open"
+)
+
+// providerErrorHints explains each kind of error to the user
+var providerErrorHints = map[string]string{
+	ProviderErrorRateLimited: "The provider's rate limit was reached. Wait a moment or lower the requests or tokens per minute for this provider.",
+	ProviderErrorOverloaded:  "The provider is overloaded. Try again shortly or pick another model.",
+	ProviderErrorServer:      "The provider had an internal error. Try again shortly.",
+	ProviderErrorAuth:        "The API key was rejected. Check the key stored for this provider.",
+	ProviderErrorRequest:     "The provider rejected the request. The prompt may be too long for the model or a setting may be invalid.",
+	ProviderErrorNetwork:     "The provider could not be reached. Check the network connection and the endpoint URL.",
+	ProviderErrorCircuitOpen: "Calls to this provider are paused after repeated failures.",
+}
+
+// ProviderError is a failed provider call
+type ProviderError struct {
+	Provider   string        `json:"provider"`
+	Kind       string        `json:"kind"`
+	StatusCode int           `json:"statusCode,omitempty"`
+	Message    string        `json:"message"`
+	RetryAfter time.Duration `json:"-"`
+	Attempts   int           `json:"attempts"`
+}
+
+func (e *ProviderError) Error() string {
+	switch {
+	case e.Kind == ProviderErrorCircuitOpen:
+		return fmt.Sprintf("%s is paused after repeated failures, retry in %ds", e.Provider, int(e.RetryAfter.Seconds()+0.5))
+	case e.StatusCode != 0:
+		return fmt.Sprintf("%s returned %d: %s", e.Provider, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("error sending request to %s: %s", e.Provider, e.Message)
+}
+
+// retryable reports whether trying the same call again may succeed
+func (e *ProviderError) retryable() bool {
+	switch e.Kind {
+	case ProviderErrorRateLimited, ProviderErrorOverloaded, ProviderErrorServer, ProviderErrorNetwork:
+		return true
+	}
+	return false
+}
+
+// Hint explains the error to the user
+func (e *ProviderError) Hint() string {
+	return providerErrorHints[e.Kind]
+}
+
+// providerError describes a failed HTTP response with the start of its body
+func providerError(provider string, response *http.Response) *ProviderError {
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = http.StatusText(response.StatusCode)
+	}
+
+	kind := ProviderErrorRequest
+	switch code := response.StatusCode; {
+	case code == http.StatusTooManyRequests:
+		kind = ProviderErrorRateLimited
+	case code == http.StatusServiceUnavailable || code == 529:
+		kind = ProviderErrorOverloaded
+	case code >= 500:
+		kind = ProviderErrorServer
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		kind = ProviderErrorAuth
+	}
+	return &ProviderError{
+		Provider:   provider,
+		Kind:       kind,
+		StatusCode: response.StatusCode,
+		Message:    message,
+		RetryAfter: retryAfter(response.Header),
+	}
+}
+
+// retryAfter reads how long the provider asks to wait, 0 when it does not say
+func retryAfter(header http.Header) time.Duration {
+	// OpenAI sends the more precise retry-after-ms next to Retry-After
+	if value := header.Get("Retry-After-Ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil && ms > 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// retryDelay returns the wait before the next attempt: exponential backoff
+// with jitter, or longer when the provider asked for it
+func retryDelay(attempt int, requested time.Duration) time.Duration {
+	delay := min(baseRetryDelay<<(attempt-1), maxRetryDelay)
+	delay += time.Duration(rand.Int63n(int64(delay) / 5))
+	return max(delay, requested)
+}
+
+// sleepContext waits for d unless ctx ends first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ProviderLimits caps the calls made to a provider; 0 means no limit
+type ProviderLimits struct {
+	RequestsPerMinute int `json:"requestsPerMinute"`
+	TokensPerMinute   int `json:"tokensPerMinute"`
+}
+
+// defaultProviderLimits match the lowest paid tiers of the hosted APIs
+var defaultProviderLimits = map[string]ProviderLimits{
+	"openai":    {RequestsPerMinute: 500, TokensPerMinute: 30000},
+	"anthropic": {RequestsPerMinute: 50, TokensPerMinute: 40000},
+}
+
+// tokenBucket refills continuously up to a minute's worth of capacity.
+// Reservations may take it below zero; the debt is the time to wait.
+type tokenBucket struct {
+	capacity  float64
+	perSecond float64
+	available float64
+	updated   time.Time
+}
+
+func newTokenBucket(perMinute int) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		capacity:  float64(perMinute),
+		perSecond: float64(perMinute) / 60,
+		available: float64(perMinute),
+		updated:   time.Now(),
+	}
+}
+
+// reserve takes n units and returns how long to wait until they are covered
+func (b *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	if b == nil || n <= 0 {
+		return 0
+	}
+	if now.After(b.updated) {
+		b.available = min(b.capacity, b.available+now.Sub(b.updated).Seconds()*b.perSecond)
+		b.updated = now
+	}
+	// A call larger than the whole bucket waits for a full bucket
+	b.available -= min(float64(n), b.capacity)
+	if b.available >= 0 {
+		return 0
+	}
+	return time.Duration(-b.available / b.perSecond * float64(time.Second))
+}
+
+// providerGuard holds the rate limits and circuit state of one endpoint
+type providerGuard struct {
+	mu        sync.Mutex
+	requests  *tokenBucket
+	tokens    *tokenBucket
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// providerGuards keeps one guard per provider and host, so a local server
+// speaking the OpenAI protocol does not share OpenAI's limits
+type providerGuards struct {
+	mu     sync.Mutex
+	limits map[string]ProviderLimits
+	guards map[string]*providerGuard
+}
+
+var guards = &providerGuards{}
+
+func isLocalHost(host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if hostname == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+// limitsFor returns the limits of a provider; the caller must hold mu
+func (g *providerGuards) limitsFor(provider string) ProviderLimits {
+	if limits, ok := g.limits[provider]; ok {
+		return limits
+	}
+	return defaultProviderLimits[provider]
+}
+
+func (g *providerGuards) get(provider string, host string) *providerGuard {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.guards == nil {
+		g.guards = map[string]*providerGuard{}
+	}
+	key := provider + "|" + host
+	guard, ok := g.guards[key]
+	if !ok {
+		guard = &providerGuard{}
+		if !isLocalHost(host) {
+			limits := g.limitsFor(provider)
+			guard.requests = newTokenBucket(limits.RequestsPerMinute)
+			guard.tokens = newTokenBucket(limits.TokensPerMinute)
+		}
+		g.guards[key] = guard
+	}
+	return guard
+}
+
+// setLimits changes the limits of a provider, dropping its current buckets
+func (g *providerGuards) setLimits(provider string, limits ProviderLimits) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limits == nil {
+		g.limits = map[string]ProviderLimits{}
+	}
+	g.limits[provider] = limits
+	for key := range g.guards {
+		if strings.HasPrefix(key, provider+"|") {
+			delete(g.guards, key)
+		}
+	}
+}
+
+// allow checks the circuit before a call. probe is true when the call is the
+// trial of an open circuit; it must be passed to record or release.
+func (g *providerGuard) allow(provider string, now time.Time) (probe bool, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.failures < circuitFailureThreshold {
+		return false, nil
+	}
+	if now.Before(g.openUntil) || g.probing {
+		return false, &ProviderError{Provider: provider, Kind: ProviderErrorCircuitOpen, RetryAfter: max(g.openUntil.Sub(now), 0)}
+	}
+	g.probing = true
+	return true, nil
+}
+
+// record updates the circuit with the outcome of a call; nil is a success
+func (g *providerGuard) record(probe bool, failure *ProviderError, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if probe {
+		g.probing = false
+	}
+	// Rejected requests show the provider is up
+	if failure == nil || !failure.retryable() {
+		g.failures = 0
+		return
+	}
+	g.failures++
+	if g.failures >= circuitFailureThreshold {
+		g.openUntil = now.Add(circuitCooldown)
+	}
+}
+
+// release ends a call that was cancelled without an outcome. Only the trial
+// call clears the probe, so an older call ending cannot start a second one.
+func (g *providerGuard) release(probe bool) {
+	if !probe {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.probing = false
+}
+
+// wait blocks until the rate limits allow a call of the given tokens
+func (g *providerGuard) wait(ctx context.Context, tokens int) error {
+	g.mu.Lock()
+	now := time.Now()
+	delay := max(g.requests.reserve(1, now), g.tokens.reserve(tokens, now))
+	g.mu.Unlock()
+	return sleepContext(ctx, delay)
+}
+
+// sendProviderRequest sends a provider call through the rate limits and the
+// circuit breaker, retrying rate limited, overloaded and failed calls with
+// backoff. tokens is the estimated size of the call for the token limit.
+// Only a successful response is returned; its body is the caller's to close.
+func sendProviderRequest(request *http.Request, provider string, tokens int) (*http.Response, error) {
+	ctx := request.Context()
+	guard := guards.get(provider, request.URL.Host)
+	probe, err := guard.allow(provider, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				guard.release(probe)
+				return nil, fmt.Errorf("error creating request: %v", err)
+			}
+			request.Body = body
+		}
+		// Retries count against the request limit but not again the token limit
+		if err := guard.wait(ctx, tokens); err != nil {
+			guard.release(probe)
+			return nil, err
+		}
+		tokens = 0
+
+		response, err := providerHTTPClient.Do(request)
+		var failure *ProviderError
+		switch {
+		case err != nil && ctx.Err() != nil:
+			guard.release(probe)
+			return nil, err
+		case err != nil:
+			failure = &ProviderError{Provider: provider, Kind: ProviderErrorNetwork, Message: err.Error()}
+		case response.StatusCode < 300:
+			guard.record(probe, nil, time.Now())
+			return response, nil
+		default:
+			failure = providerError(provider, response)
+			response.Body.Close()
+		}
+		failure.Attempts = attempt
+
+		if !failure.retryable() || attempt == maxProviderAttempts || failure.RetryAfter > maxRetryAfter {
+			guard.record(probe, failure, time.Now())
+			return nil, failure
+		}
+		if err := sleepContext(ctx, retryDelay(attempt, failure.RetryAfter)); err != nil {
+			guard.release(probe)
+			return nil, err
+		}
+	}
+}
+
+// GetProviderLimits returns the rate limits of every provider
+func (a *App) GetProviderLimits() map[string]ProviderLimits {
+	guards.mu.Lock()
+	defer guards.mu.Unlock()
+	limits := map[string]ProviderLimits{}
+	for name := range chatProviders {
+		limits[name] = guards.limitsFor(name)
+	}
+	return limits
+}
+
+// SetProviderLimits changes the rate limits of a provider for this session
+func (a *App) SetProviderLimits(provider string, limits ProviderLimits) error {
+	if _, err := resolveChatProvider(provider); err != nil {
+		return err
+	}
+	if limits.RequestsPerMinute < 0 || limits.TokensPerMinute < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	guards.setLimits(provider, limits)
+	return nil
+}
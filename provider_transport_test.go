@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stubReply struct {
+	status int
+	header map[string]string
+}
+
+func TestSendProviderRequest(t *testing.T) {
+	defer func(delay time.Duration) { baseRetryDelay = delay }(baseRetryDelay)
+	baseRetryDelay = time.Millisecond
+
+	for _, test := range []struct {
+		name     string
+		replies  []stubReply
+		kind     string
+		calls    int
+		minDelay time.Duration
+	}{
+		{name: "success", replies: []stubReply{{status: 200}}, calls: 1},
+		{name: "overloaded then success", replies: []stubReply{{status: 503}, {status: 529}, {status: 200}}, calls: 3},
+		{name: "server errors until the last attempt", replies: []stubReply{{status: 500}, {status: 502}, {status: 500}, {status: 500}}, kind: ProviderErrorServer, calls: maxProviderAttempts},
+		{name: "bad request", replies: []stubReply{{status: 400}}, kind: ProviderErrorRequest, calls: 1},
+		{name: "auth", replies: []stubReply{{status: 403}}, kind: ProviderErrorAuth, calls: 1},
+		{name: "retry-after-ms", replies: []stubReply{{status: 429, header: map[string]string{"Retry-After-Ms": "80"}}, {status: 200}}, calls: 2, minDelay: 80 * time.Millisecond},
+		{name: "retry-after beyond the limit", replies: []stubReply{{status: 429, header: map[string]string{"Retry-After": "600"}}}, kind: ProviderErrorRateLimited, calls: 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != "payload" {
+					t.Errorf("call %d sent body %q", calls+1, body)
+				}
+				reply := test.replies[min(calls, len(test.replies)-1)]
+				calls++
+				for key, value := range reply.header {
+					w.Header().Set(key, value)
+				}
+				w.WriteHeader(reply.status)
+			}))
+			defer server.Close()
+
+			request, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			started := time.Now()
+			response, err := sendProviderRequest(request, "openai", 100)
+			elapsed := time.Since(started)
+			if test.kind == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				response.Body.Close()
+			} else {
+				var providerErr *ProviderError
+				if !errors.As(err, &providerErr) {
+					t.Fatalf("error = %v, want a ProviderError", err)
+				}
+				if providerErr.Kind != test.kind || providerErr.Attempts != test.calls {
+					t.Errorf("kind = %s after %d attempts, want %s after %d", providerErr.Kind, providerErr.Attempts, test.kind, test.calls)
+				}
+			}
+			if calls != test.calls {
+				t.Errorf("calls = %d, want %d", calls, test.calls)
+			}
+			if elapsed < test.minDelay {
+				t.Errorf("returned after %v, want at least %v", elapsed, test.minDelay)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, test := range []struct {
+		header map[string]string
+		want   time.Duration
+	}{
+		{map[string]string{}, 0},
+		{map[string]string{"Retry-After": "7"}, 7 * time.Second},
+		{map[string]string{"Retry-After": "soon"}, 0},
+		{map[string]string{"Retry-After": "2", "Retry-After-Ms": "1500"}, 1500 * time.Millisecond},
+		{map[string]string{"Retry-After": time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)}, 0},
+	} {
+		header := http.Header{}
+		for key, value := range test.header {
+			header.Set(key, value)
+		}
+		if got := retryAfter(header); got != test.want {
+			t.Errorf("retryAfter(%v) = %v, want %v", test.header, got, test.want)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if got := retryAfter(header); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("retryAfter of a date an hour away = %v", got)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for _, test := range []struct {
+		attempt   int
+		requested time.Duration
+		min, max  time.Duration
+	}{
+		{1, 0, baseRetryDelay, baseRetryDelay * 6 / 5},
+		{3, 0, 4 * baseRetryDelay, 4 * baseRetryDelay * 6 / 5},
+		{10, 0, maxRetryDelay, maxRetryDelay * 6 / 5},
+		{1, time.Minute, time.Minute, time.Minute},
+	} {
+		if got := retryDelay(test.attempt, test.requested); got < test.min || got > test.max {
+			t.Errorf("retryDelay(%d, %v) = %v, want between %v and %v", test.attempt, test.requested, got, test.min, test.max)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(60)
+	bucket.updated = now
+	if wait := bucket.reserve(60, now); wait != 0 {
+		t.Errorf("a full bucket made the call wait %v", wait)
+	}
+	if wait := bucket.reserve(1, now); wait != time.Second {
+		t.Errorf("an empty bucket wait = %v, want 1s", wait)
+	}
+	// Two seconds pay the debt and refill one unit
+	if wait := bucket.reserve(1, now.Add(2*time.Second)); wait != 0 {
+		t.Errorf("a refilled bucket made the call wait %v", wait)
+	}
+	// A call larger than the bucket waits for a full bucket only
+	if wait := bucket.reserve(1000, now.Add(2*time.Second)); wait != time.Minute {
+		t.Errorf("an oversized call wait = %v, want 1m", wait)
+	}
+	if newTokenBucket(0).reserve(10, now) != 0 {
+		t.Error("an unlimited bucket made the call wait")
+	}
+
+	guard := &providerGuard{requests: newTokenBucket(60), tokens: newTokenBucket(1000)}
+	if err := guard.wait(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := guard.wait(ctx, 10); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait on an empty bucket = %v, want the context deadline", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	guard := &providerGuard{}
+	failure := &ProviderError{Provider: "openai", Kind: ProviderErrorServer}
+	for i := 0; i < circuitFailureThreshold; i++ {
+		probe, err := guard.allow("openai", now)
+		if err != nil || probe {
+			t.Fatalf("call %d: probe = %v, error = %v while the circuit is closed", i+1, probe, err)
+		}
+		guard.record(probe, failure, now)
+	}
+
+	// Open: calls fail at once until the cooldown has passed
+	var providerErr *ProviderError
+	if _, err := guard.allow("openai", now.Add(time.Second)); !errors.As(err, &providerErr) || providerErr.Kind != ProviderErrorCircuitOpen {
+		t.Fatalf("error = %v, want an open circuit", err)
+	}
+	if providerErr.RetryAfter != circuitCooldown-time.Second {
+		t.Errorf("retry after = %v, want %v", providerErr.RetryAfter, circuitCooldown-time.Second)
+	}
+
+	// Half open: a single trial call goes through
+	later := now.Add(circuitCooldown)
+	probe, err := guard.allow("openai", later)
+	if err != nil || !probe {
+		t.Fatalf("probe = %v, error = %v after the cooldown", probe, err)
+	}
+	if _, err := guard.allow("openai", later); err == nil {
+		t.Error("a second call went through while the trial call runs")
+	}
+	// A call started before the circuit opened does not end the trial
+	guard.release(false)
+	guard.record(false, failure, later)
+	if _, err := guard.allow("openai", later); err == nil {
+		t.Error("an older call ending let a second trial call through")
+	}
+
+	// A failed trial reopens the circuit and a successful one closes it
+	guard.record(probe, failure, later)
+	if _, err := guard.allow("openai", later.Add(time.Second)); err == nil {
+		t.Error("the circuit closed after a failed trial")
+	}
+	probe, err = guard.allow("openai", later.Add(circuitCooldown))
+	if err != nil || !probe {
+		t.Fatalf("probe = %v, error = %v after the second cooldown", probe, err)
+	}
+	guard.record(probe, nil, later.Add(circuitCooldown))
+	if probe, err := guard.allow("openai", later.Add(circuitCooldown)); err != nil || probe {
+		t.Errorf("probe = %v, error = %v after a successful trial", probe, err)
+	}
+
+	// A cancelled trial lets the next call try again
+	guard = &providerGuard{failures: circuitFailureThreshold, openUntil: now}
+	probe, _ = guard.allow("openai", now)
+	guard.release(probe)
+	if probe, err := guard.allow("openai", now); err != nil || !probe {
+		t.Errorf("probe = %v, error = %v after a cancelled trial", probe, err)
+	}
+}
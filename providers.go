@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	defaultChatTimeout = 5 * time.Minute
+
+	// Events emitted while a chat streams, each carrying the chat id
+	chatDeltaEvent = "chat:delta"
+	chatDoneEvent  = "chat:done"
+	chatErrorEvent = "chat:error"
+)
+
+// ChatRequest sends a prompt to a model provider
+type ChatRequest struct {
+	Provider string        `json:"provider"`
+	Model    string        `json:"model"`
+	Prompt   PromptRequest `json:"prompt"`
+	// BaseURL overrides the provider's default endpoint, e.g. a local server
+	BaseURL     string   `json:"baseUrl,omitempty"`
+	APIKey      string   `json:"apiKey,omitempty"`
+	MaxTokens   int      `json:"maxTokens,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	// PromptCaching marks the file block as cacheable where the provider supports it
+	PromptCaching  bool `json:"promptCaching"`
+	TimeoutSeconds int  `json:"timeoutSeconds,omitempty"`
+	// ForceRefresh skips the response cache and replaces the cached answer
+	ForceRefresh bool `json:"forceRefresh"`
+}
+
+// ChatUsage is the token usage reported by the provider
+type ChatUsage struct {
+	InputTokens      int `json:"inputTokens"`
+	OutputTokens     int `json:"outputTokens"`
+	CacheReadTokens  int `json:"cacheReadTokens,omitempty"`
+	CacheWriteTokens int `json:"cacheWriteTokens,omitempty"`
+	// PromptCacheHit reports that part of the prompt was read from the provider's cache
+	PromptCacheHit bool `json:"promptCacheHit"`
+}
+
+// ChatResponse is the complete answer of a chat
+type ChatResponse struct {
+	ID           string    `json:"id"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	Content      string    `json:"content"`
+	FinishReason string    `json:"finishReason"`
+	Usage        ChatUsage `json:"usage"`
+	LatencyMs    int64     `json:"latencyMs"`
+	// Cached is set when the answer came from the response cache, in which
+	// case the usage is that of the original call
+	Cached   bool       `json:"cached"`
+	CachedAt *time.Time `json:"cachedAt,omitempty"`
+}
+
+// ChatDelta is a piece of a streamed answer
+type ChatDelta struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// ChatFailure reports a chat that ended with an error. Kind and Hint are set
+// when the provider call failed, see ProviderError.
+type ChatFailure struct {
+	ID                string `json:"id"`
+	Error             string `json:"error"`
+	Kind              string `json:"kind,omitempty"`
+	Hint              string `json:"hint,omitempty"`
+	RetryAfterSeconds int    `json:"retryAfterSeconds,omitempty"`
+}
+
+// newChatFailure describes the error a chat ended with
+func newChatFailure(id string, err error) ChatFailure {
+	failure := ChatFailure{ID: id, Error: err.Error()}
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		failure.Kind = providerErr.Kind
+		failure.Hint = providerErr.Hint()
+		failure.RetryAfterSeconds = int(math.Ceil(providerErr.RetryAfter.Seconds()))
+	}
+	return failure
+}
+
+// chatProvider streams a chat completion of the already built prompt,
+// calling onDelta for every piece of text as it arrives and returning the
+// whole answer at the end. defaultBaseURL is the endpoint used when a request
+// sets no base URL.
+type chatProvider interface {
+	streamChat(ctx context.Context, request ChatRequest, split *splitPrompt, onDelta func(text string)) (*ChatResponse, error)
+	defaultBaseURL() string
+}
+
+// chatProviders maps provider names to their clients
+var chatProviders = map[string]chatProvider{
+	"openai":    openAIProvider{},
+	"anthropic": anthropicProvider{},
+	"ollama":    ollamaProvider{},
+}
+
+// providerHTTPClient is shared by the provider clients. It has no overall
+// timeout since answers stream for a while; chats bound it with a context.
+var providerHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 2 * time.Minute,
+	},
+}
+
+// chatSessions tracks the running chats so they can be cancelled
+type chatSessions struct {
+	mu      sync.Mutex
+	next    int
+	cancels map[string]context.CancelFunc
+}
+
+func (s *chatSessions) start(parent context.Context, timeout time.Duration) (string, context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancels == nil {
+		s.cancels = map[string]context.CancelFunc{}
+	}
+	s.next++
+	id := "chat-" + strconv.Itoa(s.next)
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	s.cancels[id] = cancel
+	return id, ctx
+}
+
+func (s *chatSessions) finish(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.cancels[id]; ok {
+		cancel()
+		delete(s.cancels, id)
+	}
+}
+
+// resolveChatProvider returns the client of a provider
+func resolveChatProvider(name string) (chatProvider, error) {
+	provider, ok := chatProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider: %s", name)
+	}
+	return provider, nil
+}
+
+// runChat sends a chat and waits for the complete answer
+func runChat(ctx context.Context, request ChatRequest, split *splitPrompt, onDelta func(text string)) (*ChatResponse, error) {
+	provider, err := resolveChatProvider(request.Provider)
+	if err != nil {
+		return nil, err
+	}
+	if request.Model == "" {
+		return nil, fmt.Errorf("a model is required")
+	}
+	started := time.Now()
+	response, err := provider.streamChat(ctx, request, split, onDelta)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("the %s request timed out", request.Provider)
+		}
+		if ctx.Err() == context.Canceled {
+			return nil, fmt.Errorf("the %s request was cancelled", request.Provider)
+		}
+		return nil, err
+	}
+	response.Provider = request.Provider
+	response.Usage.PromptCacheHit = response.Usage.CacheReadTokens > 0
+	response.LatencyMs = time.Since(started).Milliseconds()
+	return response, nil
+}
+
+// runCachedChat answers from the response cache when the same prompt was sent
+// to the same model before, and otherwise runs the chat and caches the answer.
+// Cache failures are logged and never fail the chat.
+func (a *App) runCachedChat(ctx context.Context, request ChatRequest, split *splitPrompt, onDelta func(text string)) (*ChatResponse, error) {
+	started := time.Now()
+	key, err := chatCacheKey(request, split)
+	if err != nil {
+		return nil, err
+	}
+	if !request.ForceRefresh {
+		cached, err := lookupCachedResponse(key)
+		if err != nil {
+			runtime.LogWarning(a.ctx, err.Error())
+		}
+		if cached != nil {
+			onDelta(cached.Content)
+			cached.LatencyMs = time.Since(started).Milliseconds()
+			return cached, nil
+		}
+	}
+
+	response, err := runChat(ctx, request, split, onDelta)
+	if err != nil {
+		return nil, err
+	}
+	if !cacheableResponse(response) {
+		return response, nil
+	}
+	if err := storeCachedResponse(key, response); err != nil {
+		runtime.LogWarning(a.ctx, err.Error())
+	}
+	return response, nil
+}
+
+// chatTimeout returns the time a chat may take
+func chatTimeout(request ChatRequest) time.Duration {
+	if request.TimeoutSeconds > 0 {
+		return time.Duration(request.TimeoutSeconds) * time.Second
+	}
+	return defaultChatTimeout
+}
+
+// StartChat sends the prompt to a provider in the background and returns the
+// chat id. The answer streams as chat:delta events and ends with a chat:done
+// event holding the whole response, or a chat:error event. Without an API key
+// in the request, the key stored in the unlocked vault is used for the
+// provider's default endpoint. A cached answer arrives as a single delta.
+func (a *App) StartChat(request ChatRequest) (string, error) {
+	if _, err := resolveChatProvider(request.Provider); err != nil {
+		return "", err
+	}
+	// Keys stored in the vault are named after their provider and only sent
+	// to its own host
+	if request.APIKey == "" && usesDefaultEndpoint(request) {
+		request.APIKey = a.vault.get(request.Provider)
+	}
+	id, ctx := a.chats.start(context.Background(), chatTimeout(request))
+	go func() {
+		defer a.chats.finish(id)
+		split, err := buildSplitPrompt(request.Prompt)
+		if err != nil {
+			runtime.EventsEmit(a.ctx, chatErrorEvent, newChatFailure(id, err))
+			return
+		}
+		response, err := a.runCachedChat(ctx, request, split, func(text string) {
+			runtime.EventsEmit(a.ctx, chatDeltaEvent, ChatDelta{ID: id, Text: text})
+		})
+		if err != nil {
+			runtime.EventsEmit(a.ctx, chatErrorEvent, newChatFailure(id, err))
+			return
+		}
+		response.ID = id
+		runtime.EventsEmit(a.ctx, chatDoneEvent, response)
+	}()
+	return id, nil
+}
+
+// CancelChat stops a running chat; it ends with a chat:error event
+func (a *App) CancelChat(id string) {
+	a.chats.finish(id)
+}
+
+// errIncompleteStream is returned by readSSE when the stream ends before
+// onEvent saw the provider's final event
+var errIncompleteStream = errors.New("the response stream ended before the reply was complete")
+
+// readSSE reads a server-sent event stream, calling onEvent for each event
+// with its type and data. Returning io.EOF from onEvent stops reading; a
+// stream that ends without it returns errIncompleteStream.
+func readSSE(body io.Reader, onEvent func(event string, data string) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	event := ""
+	var data []string
+	dispatch := func() error {
+		if len(data) == 0 {
+			event = ""
+			return nil
+		}
+		err := onEvent(event, strings.Join(data, "\n"))
+		event, data = "", nil
+		return err
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if err := dispatch(); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading response stream: %v", err)
+	}
+	if err := dispatch(); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	return errIncompleteStream
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// projectConfigFileName is the per-repository config file read from a folder root
+const projectConfigFileName = ".code-prompter.yaml"
+
+// defaultMaxFileSize is the largest file in KB included when scanning folders
+const defaultMaxFileSize = 500
+
+// stringList accepts either a list or a comma separated string, the form
+// the folder options have always used
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*l = splitList(text)
+		return nil
+	}
+	var items []string
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	*l = items
+	return nil
+}
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = splitList(node.Value)
+		return nil
+	}
+	var items []string
+	if err := node.Decode(&items); err != nil {
+		return err
+	}
+	*l = items
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(text string) []string {
+	var items []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ProjectConfig holds the settings a team can share through a
+// .code-prompter.yaml file. The same keys are read from settings.json.
+type ProjectConfig struct {
+	IgnoreFolders     stringList `json:"ignoreFolders" yaml:"ignoreFolders"`
+	IgnoreSuffixes    stringList `json:"ignoreSuffixes" yaml:"ignoreSuffixes"`
+	PinnedFiles       stringList `json:"pinnedFiles" yaml:"pinnedFiles"`
+	TaskType          string     `json:"taskType" yaml:"taskType"`
+	CustomInstruction string     `json:"customInstruction" yaml:"customInstruction"`
+	Format            string     `json:"format" yaml:"format"`
+	MaxFileSize       int        `json:"maxFileSize" yaml:"maxFileSize"`
+}
+
+// EffectiveConfig is the merged configuration of a folder. Sources lists,
+// for every key, the layers that contributed to its value. Warnings describe
+// values that were skipped because they could not be read.
+type EffectiveConfig struct {
+	Config   ProjectConfig       `json:"config"`
+	Sources  map[string][]string `json:"sources"`
+	Warnings []string            `json:"warnings,omitempty"`
+}
+
+// setKey decodes the value of one shared key, reporting whether the key is
+// known. Keys are decoded one at a time so a bad value only drops its key.
+func (c *ProjectConfig) setKey(key string, decode func(target interface{}) error) (bool, error) {
+	switch key {
+	case "ignoreFolders":
+		return true, decode(&c.IgnoreFolders)
+	case "ignoreSuffixes":
+		return true, decode(&c.IgnoreSuffixes)
+	case "pinnedFiles":
+		return true, decode(&c.PinnedFiles)
+	case "taskType":
+		return true, decode(&c.TaskType)
+	case "customInstruction":
+		return true, decode(&c.CustomInstruction)
+	case "format":
+		return true, decode(&c.Format)
+	case "maxFileSize":
+		// Number inputs in the settings screen store floats
+		var size float64
+		if err := decode(&size); err != nil {
+			return true, err
+		}
+		c.MaxFileSize = int(math.Round(size))
+		return true, nil
+	}
+	return false, nil
+}
+
+// configLayer is one source of configuration, in increasing precedence
+type configLayer struct {
+	source string
+	config ProjectConfig
+}
+
+// GetEffectiveConfig returns the configuration used for a folder: built-in
+// defaults, then settings.json, then the folder's .code-prompter.yaml
+func (a *App) GetEffectiveConfig(root string) (*EffectiveConfig, error) {
+	defaults := configLayer{source: "defaults", config: folderConfigLayer(defaultFolderConfig)}
+	defaults.config.MaxFileSize = defaultMaxFileSize
+	return loadEffectiveConfig(root, defaults)
+}
+
+// loadEffectiveConfig merges the user settings and the project file of root
+// over the given base layer. Unreadable values are skipped with a warning.
+func loadEffectiveConfig(root string, base configLayer) (*EffectiveConfig, error) {
+	layers := []configLayer{base}
+	var warnings []string
+
+	user, userWarnings, err := loadUserConfigLayer()
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, userWarnings...)
+	if user != nil {
+		layers = append(layers, *user)
+	}
+
+	project, projectWarnings, err := loadProjectConfigLayer(root)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, projectWarnings...)
+	if project != nil {
+		layers = append(layers, *project)
+	}
+
+	result := mergeConfigLayers(layers)
+	result.Warnings = warnings
+	return result, nil
+}
+
+// folderConfigLayer reads the ignore lists of the options passed to ProcessFolder
+func folderConfigLayer(config map[string]interface{}) ProjectConfig {
+	ignoreSuffixes, _ := config["ignoreSuffixes"].(string)
+	ignoreFolders, _ := config["ignoreFolders"].(string)
+	return ProjectConfig{
+		IgnoreFolders:  splitList(ignoreFolders),
+		IgnoreSuffixes: splitList(ignoreSuffixes),
+	}
+}
+
+// loadUserConfigLayer reads the shared keys of settings.json, or nil when
+// there are no user settings. The rest of the file belongs to the frontend
+// and is not looked at.
+func loadUserConfigLayer() (*configLayer, []string, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return nil, nil, nil
+	}
+	path := filepath.Join(dir, "settings.json")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("error reading settings file: %v", err)
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(content, &values); err != nil {
+		return nil, []string{fmt.Sprintf("Ignoring %s: %v", path, err)}, nil
+	}
+
+	var config ProjectConfig
+	var warnings []string
+	for key, value := range values {
+		if _, err := config.setKey(key, func(target interface{}) error {
+			return json.Unmarshal(value, target)
+		}); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Ignoring %s in %s: %v", key, path, err))
+		}
+	}
+	sort.Strings(warnings)
+	return &configLayer{source: path, config: config}, warnings, nil
+}
+
+// loadProjectConfigLayer reads the .code-prompter.yaml of root, or nil when
+// the folder has none. Unknown keys and bad values are reported as warnings.
+func loadProjectConfigLayer(root string) (*configLayer, []string, error) {
+	path := filepath.Join(root, projectConfigFileName)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("error reading %s: %v", projectConfigFileName, err)
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, []string{fmt.Sprintf("Ignoring %s: %v", path, err)}, nil
+	}
+	var config ProjectConfig
+	if len(document.Content) == 0 {
+		return &configLayer{source: path, config: config}, nil, nil
+	}
+	mapping := document.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return nil, []string{fmt.Sprintf("Ignoring %s: expected a mapping of settings", path)}, nil
+	}
+
+	var warnings []string
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		known, err := config.setKey(key.Value, value.Decode)
+		if !known {
+			warnings = append(warnings, fmt.Sprintf("Unknown key %s in %s, line %d", key.Value, path, key.Line))
+		} else if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Ignoring %s in %s, line %d: %v", key.Value, path, key.Line, err))
+		}
+	}
+	return &configLayer{source: path, config: config}, warnings, nil
+}
+
+// mergeConfigLayers applies the layers in order. Later layers replace
+// single values, while the ignore and pinned lists accumulate so a project
+// can add to the user's lists but never silently drop from them.
+func mergeConfigLayers(layers []configLayer) *EffectiveConfig {
+	result := &EffectiveConfig{Sources: map[string][]string{}}
+	merged := &result.Config
+
+	appendList := func(key string, list *stringList, items stringList, source string) {
+		added := false
+		for _, item := range items {
+			if !containsString(*list, item) {
+				*list = append(*list, item)
+				added = true
+			}
+		}
+		if added {
+			result.Sources[key] = append(result.Sources[key], source)
+		}
+	}
+	setString := func(key string, value *string, layerValue string, source string) {
+		if layerValue != "" {
+			*value = layerValue
+			result.Sources[key] = []string{source}
+		}
+	}
+
+	for _, layer := range layers {
+		appendList("ignoreFolders", &merged.IgnoreFolders, layer.config.IgnoreFolders, layer.source)
+		appendList("ignoreSuffixes", &merged.IgnoreSuffixes, layer.config.IgnoreSuffixes, layer.source)
+		appendList("pinnedFiles", &merged.PinnedFiles, layer.config.PinnedFiles, layer.source)
+		setString("taskType", &merged.TaskType, layer.config.TaskType, layer.source)
+		setString("customInstruction", &merged.CustomInstruction, layer.config.CustomInstruction, layer.source)
+		setString("format", &merged.Format, layer.config.Format, layer.source)
+		if layer.config.MaxFileSize > 0 {
+			merged.MaxFileSize = layer.config.MaxFileSize
+			result.Sources["maxFileSize"] = []string{layer.source}
+		}
+	}
+	return result
+}
+
+func containsString(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	responseCacheDirName = "response-cache"
+	responseCacheTTL     = 7 * 24 * time.Hour
+	// responseCacheMaxBytes bounds the cache; the least recently used
+	// responses are evicted first
+	responseCacheMaxBytes = 50 << 20
+)
+
+// responseCacheMu serializes writes and evictions of the cache directory
+var responseCacheMu sync.Mutex
+
+// responseCacheKey identifies everything that shapes an answer. The API key
+// and timeout are left out since they do not change the response.
+type responseCacheKey struct {
+	Provider    string   `json:"provider"`
+	BaseURL     string   `json:"baseUrl"`
+	Model       string   `json:"model"`
+	MaxTokens   int      `json:"maxTokens"`
+	Temperature *float64 `json:"temperature"`
+	System      string   `json:"system"`
+	Context     string   `json:"context"`
+	Task        string   `json:"task"`
+}
+
+type cachedResponse struct {
+	CreatedAt time.Time    `json:"createdAt"`
+	Response  ChatResponse `json:"response"`
+}
+
+// ResponseCacheStats describes the response cache for the settings screen
+type ResponseCacheStats struct {
+	Entries  int   `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"maxBytes"`
+	TTLHours int   `json:"ttlHours"`
+}
+
+func responseCacheDir() (string, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting app data directory: %v", err)
+	}
+	return filepath.Join(dir, responseCacheDirName), nil
+}
+
+// chatCacheKey hashes the final prompt with the model and its parameters.
+// An empty base URL is keyed as the provider's default so both share answers.
+func chatCacheKey(request ChatRequest, split *splitPrompt) (string, error) {
+	provider, err := resolveChatProvider(request.Provider)
+	if err != nil {
+		return "", err
+	}
+	content, err := json.Marshal(responseCacheKey{
+		Provider:    request.Provider,
+		BaseURL:     providerURL(request, provider.defaultBaseURL(), ""),
+		Model:       request.Model,
+		MaxTokens:   request.MaxTokens,
+		Temperature: request.Temperature,
+		System:      split.System,
+		Context:     split.Context,
+		Task:        split.Task,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error encoding cache key: %v", err)
+	}
+	return hashContent(string(content)), nil
+}
+
+// cacheableResponse reports whether an answer finished normally. Answers cut
+// off by the token limit or a content filter are not worth replaying.
+func cacheableResponse(response *ChatResponse) bool {
+	return response.FinishReason == "stop" || response.FinishReason == "end_turn"
+}
+
+// lookupCachedResponse returns the cached answer for a key, or nil when
+// there is none or it expired
+func lookupCachedResponse(key string) (*ChatResponse, error) {
+	dir, err := responseCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, key+".json")
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading cached response: %v", err)
+	}
+	var cached cachedResponse
+	if err := json.Unmarshal(content, &cached); err != nil {
+		return nil, fmt.Errorf("error parsing cached response: %v", err)
+	}
+	if time.Since(cached.CreatedAt) > responseCacheTTL {
+		return nil, nil
+	}
+	// The modification time records the last use for eviction
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	response := cached.Response
+	response.Cached = true
+	response.CachedAt = &cached.CreatedAt
+	return &response, nil
+}
+
+// storeCachedResponse saves an answer and evicts what no longer fits
+func storeCachedResponse(key string, response *ChatResponse) error {
+	dir, err := responseCacheDir()
+	if err != nil {
+		return err
+	}
+	cached := cachedResponse{CreatedAt: time.Now(), Response: *response}
+	cached.Response.ID, cached.Response.Cached, cached.Response.CachedAt = "", false, nil
+	content, err := json.Marshal(cached)
+	if err != nil {
+		return fmt.Errorf("error encoding cached response: %v", err)
+	}
+
+	responseCacheMu.Lock()
+	defer responseCacheMu.Unlock()
+	if err := os.MkdirAll(dir, privateDirMode); err != nil {
+		return fmt.Errorf("error creating response cache directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, key+".json"), content, privateFileMode); err != nil {
+		return fmt.Errorf("error writing cached response: %v", err)
+	}
+	return evictCachedResponses(dir)
+}
+
+// evictCachedResponses removes expired responses, then the least recently
+// used ones until the cache fits its size limit
+func evictCachedResponses(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error reading response cache: %v", err)
+	}
+	type cacheFile struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []cacheFile
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		// The TTL counts from creation, but a file untouched for longer has
+		// certainly expired
+		if time.Since(info.ModTime()) > responseCacheTTL {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, cacheFile{path: path, size: info.Size(), used: info.ModTime()})
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+	for _, file := range files {
+		if total <= responseCacheMaxBytes {
+			break
+		}
+		if err := os.Remove(file.path); err != nil {
+			return fmt.Errorf("error evicting cached response: %v", err)
+		}
+		total -= file.size
+	}
+	return nil
+}
+
+// GetResponseCacheStats returns the size of the response cache
+func (a *App) GetResponseCacheStats() (*ResponseCacheStats, error) {
+	dir, err := responseCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	stats := &ResponseCacheStats{MaxBytes: responseCacheMaxBytes, TTLHours: int(responseCacheTTL.Hours())}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading response cache: %v", err)
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			stats.Entries++
+			stats.Bytes += info.Size()
+		}
+	}
+	return stats, nil
+}
+
+// ClearResponseCache deletes every cached response
+func (a *App) ClearResponseCache() error {
+	dir, err := responseCacheDir()
+	if err != nil {
+		return err
+	}
+	responseCacheMu.Lock()
+	defer responseCacheMu.Unlock()
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("error clearing response cache: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunCachedChat(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	calls := 0
+	finishReason := "stop"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeSSE(w,
+			fmt.Sprintf(`data: {"choices":[{"delta":{"content":"answer %d"},"finish_reason":%q}]}`, calls, finishReason),
+			`data: [DONE]`,
+		)
+	}))
+	defer server.Close()
+
+	app := &App{}
+	request := testChatRequest("openai", server.URL)
+	split, err := buildSplitPrompt(request.Prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := func(request ChatRequest) *ChatResponse {
+		t.Helper()
+		response, err := app.runCachedChat(context.Background(), request, split, func(string) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	if response := chat(request); response.Cached || response.Content != "answer 1" {
+		t.Fatalf("first chat = %+v, want a fresh answer", response)
+	}
+	if response := chat(request); !response.Cached || response.Content != "answer 1" || calls != 1 {
+		t.Errorf("second chat = %+v after %d calls, want the cached answer", response, calls)
+	}
+
+	// Another model misses the cache
+	other := request
+	other.Model = "other-model"
+	if response := chat(other); response.Cached || calls != 2 {
+		t.Errorf("other model = %+v after %d calls, want a miss", response, calls)
+	}
+
+	// ForceRefresh skips the lookup and stores the new answer
+	request.ForceRefresh = true
+	if response := chat(request); response.Cached || response.Content != "answer 3" {
+		t.Errorf("forced chat = %+v, want a fresh answer", response)
+	}
+	request.ForceRefresh = false
+	if response := chat(request); !response.Cached || response.Content != "answer 3" {
+		t.Errorf("chat after refresh = %+v, want the refreshed answer", response)
+	}
+
+	// Answers cut off by the token limit are not cached
+	finishReason = "length"
+	truncated := request
+	truncated.MaxTokens = 5
+	chat(truncated)
+	if response := chat(truncated); response.Cached || calls != 5 {
+		t.Errorf("truncated chat = %+v after %d calls, want no cached answer", response, calls)
+	}
+}
+
+func TestCachedResponseExpires(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	key := hashContent("expiring")
+	if err := storeCachedResponse(key, &ChatResponse{Content: "old", FinishReason: "stop"}); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := lookupCachedResponse(key); err != nil || cached == nil || !cached.Cached {
+		t.Fatalf("fresh entry = %+v, %v", cached, err)
+	}
+
+	dir, err := responseCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, key+".json")
+	content, err := json.Marshal(cachedResponse{CreatedAt: time.Now().Add(-responseCacheTTL - time.Hour), Response: ChatResponse{Content: "old"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, privateFileMode); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := lookupCachedResponse(key); err != nil || cached != nil {
+		t.Errorf("expired entry = %+v, %v, want none", cached, err)
+	}
+}
+
+func TestChatCacheKeyNormalizesBaseURL(t *testing.T) {
+	split := &splitPrompt{Task: "Say hello."}
+	key := func(provider string, baseURL string) string {
+		t.Helper()
+		key, err := chatCacheKey(ChatRequest{Provider: provider, BaseURL: baseURL, Model: "test-model"}, split)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	for _, test := range []struct {
+		provider string
+		baseURL  string
+	}{
+		{"openai", defaultOpenAIBaseURL},
+		{"openai", defaultOpenAIBaseURL + "/"},
+		{"anthropic", defaultAnthropicBaseURL},
+		{"ollama", defaultOllamaBaseURL + "/"},
+	} {
+		if key(test.provider, "") != key(test.provider, test.baseURL) {
+			t.Errorf("%s: %q is keyed apart from the default endpoint", test.provider, test.baseURL)
+		}
+	}
+	if key("openai", "") == key("openai", "http://localhost:8080/v1") {
+		t.Error("a custom endpoint shares the default endpoint's key")
+	}
+	if _, err := chatCacheKey(ChatRequest{Provider: "nope"}, split); err == nil {
+		t.Error("an unknown provider was keyed")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 
@@ -24,25 +25,68 @@ func (a *App) ReadSettingsFile() (string, error) {
 // WriteSettingsFile writes the settings to the settings.json file
 func (a *App) WriteSettingsFile(content string) error {
 	settingsPath := filepath.Join(a.getAppDataDir(), "settings.json")
-	err := os.MkdirAll(filepath.Dir(settingsPath), 0755)
+	err := os.MkdirAll(filepath.Dir(settingsPath), privateDirMode)
 	if err != nil {
 		return fmt.Errorf("error creating settings directory: %v", err)
 	}
-	err = os.WriteFile(settingsPath, []byte(content), 0644)
+	err = os.WriteFile(settingsPath, []byte(content), privateFileMode)
 	if err != nil {
 		return fmt.Errorf("error writing settings file: %v", err)
 	}
 	return nil
 }
 
+// Everything under the app data directory is private to the user: settings,
+// history and the credential vault may all hold secrets
+const (
+	privateFileMode = 0600
+	privateDirMode  = 0700
+)
+
+// restrictAppDataPermissions makes files written by older versions with
+// world-readable permissions private
+func restrictAppDataPermissions() error {
+	dir, err := appDataDir()
+	if err != nil {
+		return err
+	}
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		mode := fs.FileMode(privateFileMode)
+		if entry.IsDir() {
+			mode = privateDirMode
+		}
+		return os.Chmod(path, mode)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error restricting app data permissions: %v", err)
+	}
+	return nil
+}
+
 // getAppDataDir returns the path to the application data directory
 func (a *App) getAppDataDir() string {
-	homeDir, err := os.UserHomeDir()
+	dir, err := appDataDir()
 	if err != nil {
 		runtime.LogError(a.ctx, fmt.Sprintf("Error getting user home directory: %v", err))
 		return ""
 	}
-	return filepath.Join(homeDir, ".code-prompter")
+	return dir
+}
+
+// appDataDir returns the path to the application data directory without
+// needing the Wails runtime
+func appDataDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".code-prompter"), nil
 }
 
 func (a *App) ReadTaskTypesFile() (string, error) {
@@ -59,11 +103,11 @@ func (a *App) ReadTaskTypesFile() (string, error) {
 
 func (a *App) WriteTaskTypesFile(content string) error {
 	taskTypesPath := filepath.Join(a.getAppDataDir(), "task_types.json")
-	err := os.MkdirAll(filepath.Dir(taskTypesPath), 0755)
+	err := os.MkdirAll(filepath.Dir(taskTypesPath), privateDirMode)
 	if err != nil {
 		return fmt.Errorf("error creating task types directory: %v", err)
 	}
-	err = os.WriteFile(taskTypesPath, []byte(content), 0644)
+	err = os.WriteFile(taskTypesPath, []byte(content), privateFileMode)
 	if err != nil {
 		return fmt.Errorf("error writing task types file: %v", err)
 	}
@@ -84,11 +128,11 @@ func (a *App) ReadCustomInstructionsFile() (string, error) {
 
 func (a *App) WriteCustomInstructionsFile(content string) error {
 	customInstructionsPath := filepath.Join(a.getAppDataDir(), "custom_instructions.json")
-    err := os.MkdirAll(filepath.Dir(customInstructionsPath), 0755)
+    err := os.MkdirAll(filepath.Dir(customInstructionsPath), privateDirMode)
     if err != nil {
         return fmt.Errorf("error creating custom instructions directory: %v", err)
     }
-    err = os.WriteFile(customInstructionsPath, []byte(content), 0644)
+    err = os.WriteFile(customInstructionsPath, []byte(content), privateFileMode)
     if err != nil {
         return fmt.Errorf("error writing custom instructions file: %v", err)
     }
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// callSiteContextLines is the number of lines shown above and below a call site
+const callSiteContextLines = 3
+
+// SymbolSource is the source code of a single function declaration
+type SymbolSource struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+	Source    string `json:"source"`
+}
+
+// CallSite is a place in the module where the selected function is called
+type CallSite struct {
+	Caller  string `json:"caller"`
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Snippet string `json:"snippet"`
+}
+
+// SymbolContext holds the call-graph context gathered for one function
+type SymbolContext struct {
+	Symbol    SymbolSource   `json:"symbol"`
+	Callees   []SymbolSource `json:"callees"`
+	CallSites []CallSite     `json:"callSites"`
+	Prompt    string         `json:"prompt"`
+}
+
+// modulePackage is a package of the local module, parsed and type-checked
+type modulePackage struct {
+	path  string
+	dir   string
+	files []*ast.File
+	pkg   *types.Package
+	info  *types.Info
+}
+
+// moduleIndex holds every local package of a Go module and resolves imports
+// between them without touching the network
+type moduleIndex struct {
+	root     string
+	fset     *token.FileSet
+	packages map[string]*modulePackage
+	checking map[string]bool
+	fallback types.Importer
+	sources  map[string][]string
+}
+
+// funcDecl ties a declared function object to its syntax
+type funcDecl struct {
+	obj  *types.Func
+	decl *ast.FuncDecl
+	pkg  *modulePackage
+}
+
+// GatherSymbolContext returns the source of a Go function, the sources of the
+// module functions it calls and its call sites, up to depth levels away
+func (a *App) GatherSymbolContext(file string, symbol string, depth int) (*SymbolContext, error) {
+	return gatherSymbolContext(file, symbol, depth)
+}
+
+func gatherSymbolContext(file string, symbol string, depth int) (*SymbolContext, error) {
+	if depth < 1 {
+		depth = 1
+	}
+
+	absoluteFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, fmt.Errorf("error getting absolute path: %v", err)
+	}
+
+	root, modulePath, err := findGoModule(filepath.Dir(absoluteFile))
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := loadModuleIndex(root, modulePath)
+	if err != nil {
+		return nil, err
+	}
+
+	decls := index.funcDecls()
+	target := index.findFunc(decls, absoluteFile, symbol)
+	if target == nil {
+		return nil, fmt.Errorf("symbol %s not found in %s", symbol, file)
+	}
+
+	calls := index.callGraph(decls)
+
+	result := &SymbolContext{Symbol: index.source(target)}
+
+	// Walk callees breadth first so closer functions come first
+	seen := map[*types.Func]bool{target.obj: true}
+	level := []*types.Func{target.obj}
+	for d := 0; d < depth && len(level) > 0; d++ {
+		var next []*types.Func
+		for _, fn := range level {
+			for _, call := range calls[fn] {
+				if seen[call.callee] {
+					continue
+				}
+				seen[call.callee] = true
+				if callee, ok := decls[call.callee]; ok {
+					result.Callees = append(result.Callees, index.source(callee))
+					next = append(next, call.callee)
+				}
+			}
+		}
+		level = next
+	}
+
+	// Walk callers the same way, reporting every call site found
+	callers := map[*types.Func][]callEdge{}
+	for _, edges := range calls {
+		for _, edge := range edges {
+			callers[edge.callee] = append(callers[edge.callee], edge)
+		}
+	}
+	seen = map[*types.Func]bool{target.obj: true}
+	level = []*types.Func{target.obj}
+	for d := 0; d < depth && len(level) > 0; d++ {
+		var next []*types.Func
+		for _, fn := range level {
+			for _, edge := range callers[fn] {
+				result.CallSites = append(result.CallSites, index.callSite(edge))
+				if !seen[edge.caller.obj] {
+					seen[edge.caller.obj] = true
+					next = append(next, edge.caller.obj)
+				}
+			}
+		}
+		level = next
+	}
+	sort.SliceStable(result.CallSites, func(i, j int) bool {
+		if result.CallSites[i].Path != result.CallSites[j].Path {
+			return result.CallSites[i].Path < result.CallSites[j].Path
+		}
+		return result.CallSites[i].Line < result.CallSites[j].Line
+	})
+
+	result.Prompt = formatSymbolContext(result)
+	return result, nil
+}
+
+// findGoModule walks up from dir until it finds a go.mod file
+func findGoModule(dir string) (string, string, error) {
+	for {
+		content, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			scanner := bufio.NewScanner(strings.NewReader(string(content)))
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if strings.HasPrefix(line, "module") {
+					modulePath := strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module")), `"`)
+					return dir, modulePath, nil
+				}
+			}
+			return "", "", fmt.Errorf("no module directive in %s", filepath.Join(dir, "go.mod"))
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", fmt.Errorf("no go.mod found above %s", dir)
+		}
+		dir = parent
+	}
+}
+
+// loadModuleIndex parses every non-test Go file of the module and type-checks
+// the packages. Type errors are tolerated so partially broken code still works.
+func loadModuleIndex(root string, modulePath string) (*moduleIndex, error) {
+	index := &moduleIndex{
+		root:     root,
+		fset:     token.NewFileSet(),
+		packages: map[string]*modulePackage{},
+		checking: map[string]bool{},
+		fallback: importer.Default(),
+		sources:  map[string][]string{},
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != root {
+				if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "vendor" || name == "testdata" || name == "node_modules" {
+					return filepath.SkipDir
+				}
+				if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		if match, err := build.Default.MatchFile(dir, info.Name()); err != nil || !match {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", path, err)
+		}
+		parsed, err := parser.ParseFile(index.fset, path, content, parser.ParseComments)
+		if parsed == nil {
+			return fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		index.sources[path] = strings.Split(string(content), "\n")
+
+		importPath := modulePath
+		if rel, _ := filepath.Rel(root, dir); rel != "." {
+			importPath = modulePath + "/" + filepath.ToSlash(rel)
+		}
+		pkg, ok := index.packages[importPath]
+		if !ok {
+			pkg = &modulePackage{path: importPath, dir: dir}
+			index.packages[importPath] = pkg
+		}
+		pkg.files = append(pkg.files, parsed)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading module: %v", err)
+	}
+
+	for path := range index.packages {
+		index.check(path)
+	}
+	return index, nil
+}
+
+// Import implements types.Importer, preferring packages of the local module
+func (m *moduleIndex) Import(path string) (*types.Package, error) {
+	if _, ok := m.packages[path]; ok {
+		if pkg := m.check(path); pkg != nil {
+			return pkg, nil
+		}
+		return nil, fmt.Errorf("import cycle through %s", path)
+	}
+	pkg, err := m.fallback.Import(path)
+	if err != nil {
+		// Unknown dependencies become empty packages; calls into them are
+		// simply not part of the module call graph
+		return types.NewPackage(path, filepath.Base(path)), nil
+	}
+	return pkg, nil
+}
+
+func (m *moduleIndex) check(path string) *types.Package {
+	pkg := m.packages[path]
+	if pkg.pkg != nil {
+		return pkg.pkg
+	}
+	if m.checking[path] {
+		return nil
+	}
+	m.checking[path] = true
+	defer delete(m.checking, path)
+
+	pkg.info = &types.Info{
+		Defs:       map[*ast.Ident]types.Object{},
+		Uses:       map[*ast.Ident]types.Object{},
+		Selections: map[*ast.SelectorExpr]*types.Selection{},
+	}
+	config := types.Config{
+		Importer:    m,
+		FakeImportC: true,
+		Error:       func(error) {},
+	}
+	pkg.pkg, _ = config.Check(path, m.fset, pkg.files, pkg.info)
+	return pkg.pkg
+}
+
+// funcDecls maps every function and method object of the module to its declaration
+func (m *moduleIndex) funcDecls() map[*types.Func]*funcDecl {
+	decls := map[*types.Func]*funcDecl{}
+	for _, pkg := range m.packages {
+		for _, file := range pkg.files {
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok {
+					continue
+				}
+				if obj, ok := pkg.info.Defs[fn.Name].(*types.Func); ok {
+					decls[obj] = &funcDecl{obj: obj, decl: fn, pkg: pkg}
+				}
+			}
+		}
+	}
+	return decls
+}
+
+// findFunc finds the declaration named by symbol in file. Methods can be given
+// as Type.Method or (*Type).Method.
+func (m *moduleIndex) findFunc(decls map[*types.Func]*funcDecl, file string, symbol string) *funcDecl {
+	receiver, name := "", symbol
+	if i := strings.LastIndex(symbol, "."); i >= 0 {
+		receiver, name = symbol[:i], symbol[i+1:]
+		receiver = strings.Trim(receiver, "()*")
+	}
+
+	for _, fd := range decls {
+		if fd.decl.Name.Name != name || m.fset.Position(fd.decl.Pos()).Filename != file {
+			continue
+		}
+		if receiverName(fd.decl) == receiver {
+			return fd
+		}
+	}
+	return nil
+}
+
+// receiverName returns the bare type name of a method receiver
+func receiverName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return ""
+	}
+	expr := decl.Recv.List[0].Type
+	for {
+		switch t := expr.(type) {
+		case *ast.StarExpr:
+			expr = t.X
+		case *ast.IndexExpr:
+			expr = t.X
+		case *ast.IndexListExpr:
+			expr = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// callEdge is one call from a module function to another
+type callEdge struct {
+	caller *funcDecl
+	callee *types.Func
+	pos    token.Pos
+}
+
+// callGraph collects, for every module function, the calls made from its body
+func (m *moduleIndex) callGraph(decls map[*types.Func]*funcDecl) map[*types.Func][]callEdge {
+	calls := map[*types.Func][]callEdge{}
+	for _, fd := range decls {
+		if fd.decl.Body == nil {
+			continue
+		}
+		caller := fd
+		ast.Inspect(fd.decl.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			var ident *ast.Ident
+			switch fun := ast.Unparen(call.Fun).(type) {
+			case *ast.Ident:
+				ident = fun
+			case *ast.SelectorExpr:
+				ident = fun.Sel
+			case *ast.IndexExpr:
+				ident = calleeIdent(fun.X)
+			case *ast.IndexListExpr:
+				ident = calleeIdent(fun.X)
+			}
+			if ident == nil {
+				return true
+			}
+			if callee, ok := caller.pkg.info.Uses[ident].(*types.Func); ok {
+				calls[caller.obj] = append(calls[caller.obj], callEdge{caller: caller, callee: callee.Origin(), pos: call.Pos()})
+			}
+			return true
+		})
+	}
+	return calls
+}
+
+func calleeIdent(expr ast.Expr) *ast.Ident {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e
+	case *ast.SelectorExpr:
+		return e.Sel
+	}
+	return nil
+}
+
+// source extracts the declaration text, including its doc comment
+func (m *moduleIndex) source(fd *funcDecl) SymbolSource {
+	start := fd.decl.Pos()
+	if fd.decl.Doc != nil {
+		start = fd.decl.Doc.Pos()
+	}
+	startPos := m.fset.Position(start)
+	endPos := m.fset.Position(fd.decl.End())
+	lines := m.sources[startPos.Filename]
+
+	return SymbolSource{
+		Name:      qualifiedFuncName(fd),
+		Path:      m.relative(startPos.Filename),
+		StartLine: startPos.Line,
+		EndLine:   endPos.Line,
+		Source:    strings.Join(lines[startPos.Line-1:endPos.Line], "\n"),
+	}
+}
+
+// callSite extracts the lines surrounding a call
+func (m *moduleIndex) callSite(edge callEdge) CallSite {
+	pos := m.fset.Position(edge.pos)
+	lines := m.sources[pos.Filename]
+	from := max(pos.Line-callSiteContextLines, 1)
+	to := min(pos.Line+callSiteContextLines, len(lines))
+
+	var snippet strings.Builder
+	for i := from; i <= to; i++ {
+		marker := " "
+		if i == pos.Line {
+			marker = ">"
+		}
+		fmt.Fprintf(&snippet, "%s%5d | %s\n", marker, i, lines[i-1])
+	}
+
+	return CallSite{
+		Caller:  qualifiedFuncName(edge.caller),
+		Path:    m.relative(pos.Filename),
+		Line:    pos.Line,
+		Snippet: strings.TrimSuffix(snippet.String(), "\n"),
+	}
+}
+
+func (m *moduleIndex) relative(path string) string {
+	rel, err := filepath.Rel(m.root, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// qualifiedFuncName renders a function as pkg.Func or pkg.(*Type).Method
+func qualifiedFuncName(fd *funcDecl) string {
+	name := fd.decl.Name.Name
+	if recv := receiverName(fd.decl); recv != "" {
+		if _, ok := fd.decl.Recv.List[0].Type.(*ast.StarExpr); ok {
+			name = "(*" + recv + ")." + name
+		} else {
+			name = recv + "." + name
+		}
+	}
+	return fd.obj.Pkg().Name() + "." + name
+}
+
+// formatSymbolContext renders the gathered context as a prompt section
+func formatSymbolContext(ctx *SymbolContext) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Function %s (%s:%d-%d):\n%s\n", ctx.Symbol.Name, ctx.Symbol.Path, ctx.Symbol.StartLine, ctx.Symbol.EndLine, ctx.Symbol.Source)
+
+	if len(ctx.Callees) > 0 {
+		b.WriteString("\nFunctions it calls:\n")
+		for _, callee := range ctx.Callees {
+			fmt.Fprintf(&b, "\n%s (%s:%d-%d):\n%s\n", callee.Name, callee.Path, callee.StartLine, callee.EndLine, callee.Source)
+		}
+	}
+
+	if len(ctx.CallSites) > 0 {
+		b.WriteString("\nCall sites:\n")
+		for _, site := range ctx.CallSites {
+			fmt.Fprintf(&b, "\n%s:%d in %s:\n%s\n", site.Path, site.Line, site.Caller, site.Snippet)
+		}
+	}
+
+	return b.String()
+}
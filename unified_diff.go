@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// diffContextLines is the number of unchanged lines around each hunk
+	diffContextLines = 3
+	// maxDiffEdits bounds the work of a diff; beyond it the new version is
+	// clearer than the diff anyway
+	maxDiffEdits = 1000
+)
+
+// diffOp is one line of an edit script: ' ' kept, '-' deleted or '+' inserted
+type diffOp struct {
+	kind byte
+	text string
+}
+
+// diffLines computes the shortest edit script from a to b with Myers'
+// algorithm, reporting false when it needs more than maxEdits edits
+func diffLines(a []string, b []string, maxEdits int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxEdits {
+		limit = maxEdits
+	}
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(trace, offset, a, b), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// backtrackDiff walks the saved states back from the end to recover the edits
+func backtrackDiff(trace [][]int, offset int, a []string, b []string) []diffOp {
+	var ops []diffOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{kind: ' ', text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{kind: '+', text: b[y-1]})
+				y--
+			} else {
+				ops = append(ops, diffOp{kind: '-', text: a[x-1]})
+				x--
+			}
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// splitDiffLines splits text into lines without their line breaks
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// unifiedDiff renders the changes from oldText to newText in unified diff
+// format. It reports false when the texts differ too much for a useful diff.
+func unifiedDiff(path string, oldText string, newText string) (string, bool) {
+	ops, ok := diffLines(splitDiffLines(oldText), splitDiffLines(newText), maxDiffEdits)
+	if !ok {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(filepath.ToSlash(path), "/")
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", name, name)
+
+	// Changes closer than twice the context share a hunk
+	for start := 0; start < len(ops); {
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		end := start
+		for i := start; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*diffContextLines {
+				break
+			}
+		}
+		from := start - diffContextLines
+		if from < 0 {
+			from = 0
+		}
+		to := end + diffContextLines
+		if to > len(ops) {
+			to = len(ops)
+		}
+		writeHunk(&b, ops, from, to)
+		start = to
+	}
+	return b.String(), true
+}
+
+// writeHunk writes ops[from:to] with its @@ header
+func writeHunk(b *strings.Builder, ops []diffOp, from int, to int) {
+	oldStart, newStart := 1, 1
+	for _, op := range ops[:from] {
+		if op.kind != '+' {
+			oldStart++
+		}
+		if op.kind != '-' {
+			newStart++
+		}
+	}
+	oldCount, newCount := 0, 0
+	for _, op := range ops[from:to] {
+		if op.kind != '+' {
+			oldCount++
+		}
+		if op.kind != '-' {
+			newCount++
+		}
+	}
+	// An empty side is numbered after the line it follows
+	if oldCount == 0 {
+		oldStart--
+	}
+	if newCount == 0 {
+		newStart--
+	}
+
+	fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+	for _, op := range ops[from:to] {
+		b.WriteByte(op.kind)
+		b.WriteString(op.text)
+		b.WriteByte('\n')
+	}
+}
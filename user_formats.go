@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// userFormatExtension is the file extension of user defined format templates
+const userFormatExtension = ".tmpl"
+
+var (
+	userFormatNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	templateErrorPattern  = regexp.MustCompile(`^template: [^:]*:(\d+)(?::\d+)?: (.*)$`)
+)
+
+// TemplateData is the data model user format templates are rendered against.
+//
+//	.Task          task type description
+//	.Instructions  custom instruction description
+//	.RawPrompt     the task instruction typed by the user
+//	.Note          note to show before the files, may be empty
+//	.Tree          project tree section, empty unless requested
+//	.Diff          diff attached to the request, may be empty
+//	.Files         selected files, see TemplateFile
+//
+// Helper functions:
+//
+//	fence LANG TEXT   fenced code block that TEXT cannot break out of
+//	escapeXML TEXT    TEXT escaped for use between XML tags
+//	cdata TEXT        TEXT wrapped in a CDATA section
+//	tokens TEXT       estimated token count of TEXT
+//	indent N TEXT     TEXT with every line indented by N spaces
+type TemplateData struct {
+	Task         string
+	Instructions string
+	RawPrompt    string
+	Note         string
+	Tree         string
+	Diff         string
+	Files        []TemplateFile
+}
+
+// TemplateFile is a selected file as seen by format templates. Ranges is
+// empty for whole files and lists the included lines of excerpts.
+type TemplateFile struct {
+	Path      string
+	Language  string
+	Content   string
+	Lines     int
+	Tokens    int
+	Truncated bool
+	Ranges    string
+}
+
+// TemplateError is a problem in a format template, located at a line of it
+type TemplateError struct {
+	Name    string `json:"name"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e *TemplateError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("format %s, line %d: %s", e.Name, e.Line, e.Message)
+	}
+	return fmt.Sprintf("format %s: %s", e.Name, e.Message)
+}
+
+// templateFuncs are the helpers available to format templates
+var templateFuncs = template.FuncMap{
+	"fence": func(language string, text string) string {
+		fence := codeFence(text)
+		return fence + language + "\n" + strings.TrimSuffix(text, "\n") + "\n" + fence
+	},
+	"escapeXML": escapeXMLText,
+	"cdata":     cdata,
+	"tokens":    estimateTokens,
+	"indent": func(spaces int, text string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.ReplaceAll(text, "\n", "\n"+pad)
+	},
+}
+
+// userFormatsDir returns the folder holding the user defined format templates
+func userFormatsDir() (string, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting user home directory: %v", err)
+	}
+	return filepath.Join(dir, "formats"), nil
+}
+
+// userFormatPath validates a format name and returns its template file
+func userFormatPath(name string) (string, error) {
+	if !userFormatNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid format name: %s", name)
+	}
+	dir, err := userFormatsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+userFormatExtension), nil
+}
+
+// listUserFormats returns the names of the stored format templates
+func listUserFormats() []string {
+	dir, err := userFormatsDir()
+	if err != nil {
+		return nil
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+userFormatExtension))
+	var names []string
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(match), userFormatExtension))
+	}
+	return names
+}
+
+// parseFormatTemplate parses a template, reporting errors with their line number
+func parseFormatTemplate(name string, content string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(content)
+	if err != nil {
+		return nil, newTemplateError(name, err)
+	}
+	return tmpl, nil
+}
+
+// newTemplateError extracts the line number from a text/template error
+func newTemplateError(name string, err error) *TemplateError {
+	templateErr := &TemplateError{Name: name, Message: err.Error()}
+	if match := templateErrorPattern.FindStringSubmatch(err.Error()); match != nil {
+		templateErr.Line, _ = strconv.Atoi(match[1])
+		templateErr.Message = match[2]
+	}
+	return templateErr
+}
+
+// loadUserFormat returns a renderer for a stored template, or false when no
+// template with that name exists
+func loadUserFormat(name string) (promptRenderer, bool, error) {
+	path, err := userFormatPath(name)
+	if err != nil {
+		return nil, false, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("error reading format template: %v", err)
+	}
+
+	tmpl, err := parseFormatTemplate(name, string(content))
+	if err != nil {
+		return nil, true, err
+	}
+
+	return func(data *promptData) (string, error) {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, newTemplateData(data)); err != nil {
+			return "", newTemplateError(name, err)
+		}
+		return b.String(), nil
+	}, true, nil
+}
+
+// newTemplateData converts prompt data to the documented template data model
+func newTemplateData(data *promptData) TemplateData {
+	result := TemplateData{
+		Task:         data.Task,
+		Instructions: data.Instructions,
+		RawPrompt:    data.RawPrompt,
+		Note:         data.Note,
+		Tree:         data.Tree,
+		Diff:         data.Diff,
+	}
+	for _, file := range data.Files {
+		templateFile := TemplateFile{
+			Path:      file.Path,
+			Language:  file.Language,
+			Content:   file.Content,
+			Lines:     file.Lines,
+			Tokens:    estimateTokens(file.Content),
+			Truncated: file.Excerpt(),
+		}
+		if file.Excerpt() {
+			templateFile.Ranges = formatRanges(file.Ranges)
+		}
+		result.Files = append(result.Files, templateFile)
+	}
+	return result
+}
+
+// ReadFormatTemplate returns the content of a user defined format template
+func (a *App) ReadFormatTemplate(name string) (string, error) {
+	path, err := userFormatPath(name)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading format template: %v", err)
+	}
+	return string(content), nil
+}
+
+// WriteFormatTemplate validates and stores a user defined format template
+func (a *App) WriteFormatTemplate(name string, content string) error {
+	if _, builtIn := promptFormats[strings.ToLower(name)]; builtIn {
+		return fmt.Errorf("format %s is built in and cannot be replaced", name)
+	}
+	path, err := userFormatPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := parseFormatTemplate(name, content); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), privateDirMode); err != nil {
+		return fmt.Errorf("error creating formats directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), privateFileMode); err != nil {
+		return fmt.Errorf("error writing format template: %v", err)
+	}
+	return nil
+}
+
+// DeleteFormatTemplate removes a user defined format template
+func (a *App) DeleteFormatTemplate(name string) error {
+	path, err := userFormatPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error deleting format template: %v", err)
+	}
+	return nil
+}
+
+// ValidateFormatTemplate checks a template without storing it, returning nil
+// when it parses
+func (a *App) ValidateFormatTemplate(name string, content string) *TemplateError {
+	if _, err := parseFormatTemplate(name, content); err != nil {
+		return err.(*TemplateError)
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	vaultFileName = "credentials.vault"
+	vaultVersion  = 1
+
+	// scrypt parameters recommended for interactive logins
+	vaultScryptN   = 1 << 15
+	vaultScryptR   = 8
+	vaultScryptP   = 1
+	vaultKeyLength = 32
+	vaultSaltSize  = 16
+
+	// Upper bounds on the parameters read from a vault file, so a damaged
+	// or crafted file cannot make unlocking take minutes or gigabytes
+	vaultMaxScryptN      = 1 << 20
+	vaultMaxScryptR      = 32
+	vaultMaxScryptP      = 16
+	vaultMaxScryptMemory = 256 << 20
+)
+
+// vaultAdditionalData binds the ciphertext to this file format
+var vaultAdditionalData = []byte("code-prompter credentials v1")
+
+// vaultFile is the on-disk vault. Only the secrets are encrypted; the key
+// derivation parameters are stored so they can be raised later.
+type vaultFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// VaultStatus tells the UI whether to ask for a new or the existing passphrase
+type VaultStatus struct {
+	Exists   bool `json:"exists"`
+	Unlocked bool `json:"unlocked"`
+}
+
+// StoredKey is an API key as listed to the UI, never in full
+type StoredKey struct {
+	Name   string `json:"name"`
+	Masked string `json:"masked"`
+}
+
+// scryptParams are the cost parameters a vault key was derived with
+type scryptParams struct {
+	N, R, P int
+}
+
+// check rejects parameters beyond the bounds this app is willing to run
+func (s scryptParams) check() error {
+	if s.N > vaultMaxScryptN || s.R > vaultMaxScryptR || s.P > vaultMaxScryptP || 128*s.N*s.R > vaultMaxScryptMemory {
+		return fmt.Errorf("unsupported vault key parameters N=%d r=%d p=%d", s.N, s.R, s.P)
+	}
+	return nil
+}
+
+// credentialVault holds the decrypted API keys for the session once the
+// passphrase has been entered, with the parameters the key was derived with
+type credentialVault struct {
+	mu      sync.Mutex
+	key     []byte
+	salt    []byte
+	params  scryptParams
+	secrets map[string]string
+}
+
+// vaultPath returns the path of the vault file
+func vaultPath() (string, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting app data directory: %v", err)
+	}
+	return filepath.Join(dir, vaultFileName), nil
+}
+
+// deriveVaultKey stretches a passphrase into an AES-256 key
+func deriveVaultKey(passphrase string, salt []byte, params scryptParams) ([]byte, error) {
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, vaultKeyLength)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving vault key: %v", err)
+	}
+	return key, nil
+}
+
+// create makes a new empty vault and unlocks it. The passphrase is asked
+// twice so a typo cannot lock the user out of the keys saved next.
+func (v *credentialVault) create(passphrase string, confirmation string) error {
+	if passphrase == "" {
+		return fmt.Errorf("a passphrase is required")
+	}
+	if passphrase != confirmation {
+		return fmt.Errorf("the passphrases do not match")
+	}
+	path, err := vaultPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("a credential vault already exists")
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error reading vault: %v", err)
+	}
+
+	salt := make([]byte, vaultSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("error generating salt: %v", err)
+	}
+	params := scryptParams{N: vaultScryptN, R: vaultScryptR, P: vaultScryptP}
+	key, err := deriveVaultKey(passphrase, salt, params)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.key, v.salt, v.params, v.secrets = key, salt, params, map[string]string{}
+	return v.save()
+}
+
+// unlock decrypts an existing vault
+func (v *credentialVault) unlock(passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("a passphrase is required")
+	}
+	path, err := vaultPath()
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("there is no credential vault yet")
+	}
+	if err != nil {
+		return fmt.Errorf("error reading vault: %v", err)
+	}
+
+	var file vaultFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return fmt.Errorf("error parsing vault: %v", err)
+	}
+	if file.Version != vaultVersion || file.KDF != "scrypt" {
+		return fmt.Errorf("unsupported vault version %d (%s)", file.Version, file.KDF)
+	}
+	params := scryptParams{N: file.N, R: file.R, P: file.P}
+	key, err := deriveVaultKey(passphrase, file.Salt, params)
+	if err != nil {
+		return err
+	}
+	gcm, err := newVaultCipher(key)
+	if err != nil {
+		return err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, vaultAdditionalData)
+	if err != nil {
+		return fmt.Errorf("incorrect passphrase")
+	}
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return fmt.Errorf("error parsing vault contents: %v", err)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.key, v.salt, v.params, v.secrets = key, file.Salt, params, secrets
+	return nil
+}
+
+// lock forgets the decrypted keys
+func (v *credentialVault) lock() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.key, v.salt, v.params, v.secrets = nil, nil, scryptParams{}, nil
+}
+
+func (v *credentialVault) unlocked() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.key != nil
+}
+
+// get returns a stored key, or an empty string when the vault is locked
+func (v *credentialVault) get(name string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.secrets[name]
+}
+
+// update changes the secrets and writes the vault; the caller must not hold mu
+func (v *credentialVault) update(change func(secrets map[string]string)) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return fmt.Errorf("the credential vault is locked")
+	}
+	change(v.secrets)
+	return v.save()
+}
+
+// save encrypts the secrets with a fresh nonce and replaces the vault file
+// atomically; the caller must hold mu
+func (v *credentialVault) save() error {
+	plaintext, err := json.Marshal(v.secrets)
+	if err != nil {
+		return fmt.Errorf("error encoding vault contents: %v", err)
+	}
+	gcm, err := newVaultCipher(v.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("error generating nonce: %v", err)
+	}
+	content, err := json.MarshalIndent(vaultFile{
+		Version:    vaultVersion,
+		KDF:        "scrypt",
+		N:          v.params.N,
+		R:          v.params.R,
+		P:          v.params.P,
+		Salt:       v.salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, vaultAdditionalData),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding vault: %v", err)
+	}
+
+	path, err := vaultPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), privateDirMode); err != nil {
+		return fmt.Errorf("error creating vault directory: %v", err)
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, content, privateFileMode); err != nil {
+		return fmt.Errorf("error writing vault: %v", err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("error writing vault: %v", err)
+	}
+	return nil
+}
+
+func newVaultCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault cipher: %v", err)
+	}
+	return gcm, nil
+}
+
+// maskKey shows just enough of a key to tell keys apart
+func maskKey(key string) string {
+	if utf8.RuneCountInString(key) < 12 {
+		return "••••"
+	}
+	runes := []rune(key)
+	return string(runes[:3]) + "••••" + string(runes[len(runes)-4:])
+}
+
+// GetVaultStatus reports whether the credential vault exists and is unlocked
+func (a *App) GetVaultStatus() (VaultStatus, error) {
+	path, err := vaultPath()
+	if err != nil {
+		return VaultStatus{}, err
+	}
+	_, err = os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return VaultStatus{}, fmt.Errorf("error reading vault: %v", err)
+	}
+	return VaultStatus{Exists: err == nil, Unlocked: a.vault.unlocked()}, nil
+}
+
+// CreateVault creates the credential vault and unlocks it for the session.
+// confirmation is the passphrase typed a second time.
+func (a *App) CreateVault(passphrase string, confirmation string) error {
+	return a.vault.create(passphrase, confirmation)
+}
+
+// UnlockVault decrypts the credential vault for the rest of the session
+func (a *App) UnlockVault(passphrase string) error {
+	return a.vault.unlock(passphrase)
+}
+
+// LockVault forgets the decrypted keys until the vault is unlocked again
+func (a *App) LockVault() {
+	a.vault.lock()
+}
+
+// SetAPIKey stores the API key of a provider in the vault
+func (a *App) SetAPIKey(name string, key string) error {
+	if name == "" || key == "" {
+		return fmt.Errorf("a name and a key are required")
+	}
+	return a.vault.update(func(secrets map[string]string) {
+		secrets[name] = key
+	})
+}
+
+// ListAPIKeys returns the stored keys, masked
+func (a *App) ListAPIKeys() ([]StoredKey, error) {
+	a.vault.mu.Lock()
+	defer a.vault.mu.Unlock()
+	if a.vault.key == nil {
+		return nil, fmt.Errorf("the credential vault is locked")
+	}
+	keys := make([]StoredKey, 0, len(a.vault.secrets))
+	for name, key := range a.vault.secrets {
+		keys = append(keys, StoredKey{Name: name, Masked: maskKey(key)})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// DeleteAPIKey removes the API key of a provider from the vault
+func (a *App) DeleteAPIKey(name string) error {
+	return a.vault.update(func(secrets map[string]string) {
+		delete(secrets, name)
+	})
+}
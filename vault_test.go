@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readVaultFile(t *testing.T) vaultFile {
+	t.Helper()
+	path, err := vaultPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file vaultFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestVaultKeepsItsKeyParameters(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	// A vault written with other parameters than the current defaults
+	params := scryptParams{N: 1 << 10, R: 8, P: 1}
+	salt := []byte("0123456789abcdef")
+	key, err := deriveVaultKey("passphrase", salt, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := credentialVault{key: key, salt: salt, params: params, secrets: map[string]string{}}
+	if err := old.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var vault credentialVault
+	if err := vault.unlock("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.update(func(secrets map[string]string) { secrets["openai"] = "sk-test" }); err != nil {
+		t.Fatal(err)
+	}
+	if file := readVaultFile(t); file.N != params.N || file.R != params.R || file.P != params.P {
+		t.Errorf("saved parameters = %d %d %d, want %+v", file.N, file.R, file.P, params)
+	}
+
+	var reopened credentialVault
+	if err := reopened.unlock("passphrase"); err != nil {
+		t.Fatalf("the saved vault cannot be unlocked: %v", err)
+	}
+	if got := reopened.get("openai"); got != "sk-test" {
+		t.Errorf("stored key = %q", got)
+	}
+	if err := (&credentialVault{}).unlock("wrong"); err == nil {
+		t.Error("a wrong passphrase unlocked the vault")
+	}
+}
+
+func TestVaultRejectsExcessiveKeyParameters(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	params := scryptParams{N: vaultScryptN, R: vaultScryptR, P: vaultScryptP}
+	salt := []byte("0123456789abcdef")
+	key, err := deriveVaultKey("passphrase", salt, scryptParams{N: 1 << 10, R: 8, P: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, excessive := range []scryptParams{
+		{N: 1 << 30, R: params.R, P: params.P},
+		{N: params.N, R: 1 << 20, P: params.P},
+		{N: params.N, R: params.R, P: 1 << 20},
+		{N: vaultMaxScryptN, R: vaultMaxScryptR, P: 1},
+	} {
+		vault := credentialVault{key: key, salt: salt, params: excessive, secrets: map[string]string{}}
+		if err := vault.save(); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&credentialVault{}).unlock("passphrase"); err == nil || !strings.Contains(err.Error(), "unsupported vault key parameters") {
+			t.Errorf("unlock with %+v: %v", excessive, err)
+		}
+	}
+}
+
+func TestVaultCreateAndPermissions(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	app := &App{}
+	if err := app.UnlockVault("passphrase"); err == nil {
+		t.Error("unlocking created a missing vault")
+	}
+	if err := app.CreateVault("passphrase", "passphrasf"); err == nil {
+		t.Error("a vault was created with mismatched passphrases")
+	}
+	if status, err := app.GetVaultStatus(); err != nil || status.Exists || status.Unlocked {
+		t.Fatalf("status = %+v, %v before creating the vault", status, err)
+	}
+	if err := app.CreateVault("passphrase", "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := app.GetVaultStatus(); err != nil || !status.Exists || !status.Unlocked {
+		t.Errorf("status = %+v, %v after creating the vault", status, err)
+	}
+	if err := app.CreateVault("other", "other"); err == nil {
+		t.Error("an existing vault was replaced")
+	}
+
+	path, err := vaultPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path string
+		mode os.FileMode
+	}{
+		{path, 0600},
+		{filepath.Dir(path), 0700 | os.ModeDir},
+	} {
+		info, err := os.Stat(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != test.mode {
+			t.Errorf("%s has mode %v, want %v", test.path, info.Mode(), test.mode)
+		}
+	}
+}
+
+func TestVaultKeysWhileLocked(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	app := &App{}
+	// A cheap key keeps the test fast
+	params := scryptParams{N: 1 << 10, R: 8, P: 1}
+	salt := []byte("0123456789abcdef")
+	key, err := deriveVaultKey("passphrase", salt, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.vault = credentialVault{key: key, salt: salt, params: params, secrets: map[string]string{}}
+	if err := app.SetAPIKey("openai", "sk-proj-abcdefghijklmnop"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.SetAPIKey("anthropic", "short"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := app.ListAPIKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StoredKey{{Name: "anthropic", Masked: "••••"}, {Name: "openai", Masked: "sk-••••mnop"}}
+	if len(keys) != 2 || keys[0] != want[0] || keys[1] != want[1] {
+		t.Errorf("keys = %+v, want %+v", keys, want)
+	}
+
+	app.LockVault()
+	if _, err := app.ListAPIKeys(); err == nil {
+		t.Error("the keys of a locked vault were listed")
+	}
+	if err := app.DeleteAPIKey("openai"); err == nil {
+		t.Error("a key was deleted from a locked vault")
+	}
+	if err := app.SetAPIKey("openai", "sk-other"); err == nil {
+		t.Error("a key was stored in a locked vault")
+	}
+	if app.vault.get("openai") != "" {
+		t.Error("a locked vault returned a key")
+	}
+
+	// Unlocked again, the key is still there and can be deleted
+	if err := app.UnlockVault("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.DeleteAPIKey("openai"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := app.ListAPIKeys(); err != nil || len(keys) != 1 || keys[0].Name != "anthropic" {
+		t.Errorf("keys after deleting = %+v, %v", keys, err)
+	}
+}
+
+func TestMaskKey(t *testing.T) {
+	for key, want := range map[string]string{
+		"":                        "••••",
+		"sk-12345678":             "••••",
+		"sk-123456789":            "sk-••••6789",
+		"sk-ant-api03-abcdefwxyz": "sk-••••wxyz",
+		"ключ-абвгдежзий":         "клю••••жзий",
+	} {
+		if got := maskKey(key); got != want {
+			t.Errorf("maskKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
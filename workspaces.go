@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const workspaceExtension = ".json"
+
+var workspaceNamePattern = regexp.MustCompile(`^[\w][\w .-]*[\w-]$|^[\w]$`)
+
+// Workspace is a saved session: the folders, selected files, filters and
+// prompt choices. Selected paths are absolute here and stored relative to
+// the root that contains them, so a workspace survives moving the checkout.
+type Workspace struct {
+	Name               string    `json:"name"`
+	Roots              []string  `json:"roots"`
+	SelectedPaths      []string  `json:"selectedPaths"`
+	IncludedExtensions []string  `json:"includedExtensions"`
+	ExcludedExtensions []string  `json:"excludedExtensions"`
+	RespectGitignore   bool      `json:"respectGitignore"`
+	TaskType           string    `json:"taskType"`
+	CustomInstruction  string    `json:"customInstruction"`
+	Format             string    `json:"format"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// WorkspaceSummary describes a saved workspace for listing
+type WorkspaceSummary struct {
+	Name      string    `json:"name"`
+	Roots     []string  `json:"roots"`
+	Files     int       `json:"files"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// workspacePath is a selected path relative to one of the workspace roots.
+// Root is -1 for paths outside every root, which are kept absolute.
+type workspacePath struct {
+	Root int    `json:"root"`
+	Path string `json:"path"`
+}
+
+// storedWorkspace is the on-disk form of a workspace
+type storedWorkspace struct {
+	Workspace
+	SelectedPaths []workspacePath `json:"selectedPaths"`
+}
+
+// workspacesDir returns the folder holding saved workspaces
+func workspacesDir() (string, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting user home directory: %v", err)
+	}
+	return filepath.Join(dir, "workspaces"), nil
+}
+
+// workspaceFile validates a workspace name and returns its file
+func workspaceFile(name string) (string, error) {
+	if !workspaceNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid workspace name: %q", name)
+	}
+	dir, err := workspacesDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+workspaceExtension), nil
+}
+
+// SaveWorkspace stores the workspace under its name, replacing any previous version
+func (a *App) SaveWorkspace(workspace Workspace) error {
+	return saveWorkspace(workspace)
+}
+
+func saveWorkspace(workspace Workspace) error {
+	path, err := workspaceFile(workspace.Name)
+	if err != nil {
+		return err
+	}
+
+	stored := storedWorkspace{Workspace: workspace}
+	stored.UpdatedAt = time.Now().UTC()
+	stored.Roots = append([]string{}, workspace.Roots...)
+	for i, root := range stored.Roots {
+		if absoluteRoot, err := filepath.Abs(root); err == nil {
+			stored.Roots[i] = absoluteRoot
+		}
+	}
+	for _, selected := range workspace.SelectedPaths {
+		stored.SelectedPaths = append(stored.SelectedPaths, relativeWorkspacePath(stored.Roots, selected))
+	}
+
+	content, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding workspace: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), privateDirMode); err != nil {
+		return fmt.Errorf("error creating workspaces directory: %v", err)
+	}
+	if err := os.WriteFile(path, content, privateFileMode); err != nil {
+		return fmt.Errorf("error writing workspace file: %v", err)
+	}
+	return nil
+}
+
+// relativeWorkspacePath expresses a path relative to the deepest root containing it
+func relativeWorkspacePath(roots []string, selected string) workspacePath {
+	absolutePath, err := filepath.Abs(selected)
+	if err != nil {
+		return workspacePath{Root: -1, Path: selected}
+	}
+	best := workspacePath{Root: -1, Path: absolutePath}
+	bestLength := -1
+	for i, root := range roots {
+		rel, err := filepath.Rel(root, absolutePath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(root) > bestLength {
+			best = workspacePath{Root: i, Path: filepath.ToSlash(rel)}
+			bestLength = len(root)
+		}
+	}
+	return best
+}
+
+// LoadWorkspace reads a saved workspace, resolving its selected paths
+// against the roots again
+func (a *App) LoadWorkspace(name string) (*Workspace, error) {
+	return loadWorkspace(name)
+}
+
+func loadWorkspace(name string) (*Workspace, error) {
+	path, err := workspaceFile(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("workspace %s not found", name)
+		}
+		return nil, fmt.Errorf("error reading workspace file: %v", err)
+	}
+
+	var stored storedWorkspace
+	if err := json.Unmarshal(content, &stored); err != nil {
+		return nil, fmt.Errorf("error parsing workspace file: %v", err)
+	}
+
+	workspace := stored.Workspace
+	workspace.SelectedPaths = nil
+	for _, selected := range stored.SelectedPaths {
+		if selected.Root < 0 || selected.Root >= len(workspace.Roots) {
+			workspace.SelectedPaths = append(workspace.SelectedPaths, filepath.FromSlash(selected.Path))
+			continue
+		}
+		workspace.SelectedPaths = append(workspace.SelectedPaths, filepath.Join(workspace.Roots[selected.Root], filepath.FromSlash(selected.Path)))
+	}
+	return &workspace, nil
+}
+
+// ListWorkspaces returns the saved workspaces, most recently updated first
+func (a *App) ListWorkspaces() ([]WorkspaceSummary, error) {
+	dir, err := workspacesDir()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+workspaceExtension))
+	if err != nil {
+		return nil, fmt.Errorf("error listing workspaces: %v", err)
+	}
+
+	summaries := []WorkspaceSummary{}
+	for _, match := range matches {
+		workspace, err := loadWorkspace(strings.TrimSuffix(filepath.Base(match), workspaceExtension))
+		if err != nil {
+			continue
+		}
+		summaries = append(summaries, WorkspaceSummary{
+			Name:      workspace.Name,
+			Roots:     workspace.Roots,
+			Files:     len(workspace.SelectedPaths),
+			UpdatedAt: workspace.UpdatedAt,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt)
+	})
+	return summaries, nil
+}
+
+// DeleteWorkspace removes a saved workspace and its incremental snapshot
+func (a *App) DeleteWorkspace(name string) error {
+	path, err := workspaceFile(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("workspace %s not found", name)
+		}
+		return fmt.Errorf("error deleting workspace file: %v", err)
+	}
+	return removeWorkspaceSnapshot(name)
+}